@@ -7,6 +7,7 @@ import (
 
 	"github.com/MlDenis/internal/accrual/models"
 	"github.com/MlDenis/internal/accrual/storage"
+	"github.com/MlDenis/internal/amount"
 	"go.uber.org/zap"
 )
 
@@ -40,19 +41,19 @@ func OrdersGoodsGorutine(ctx context.Context, ordersChan chan models.GoodsWithRe
 	rewards, err := s.GetAllRewards(ctx)
 	if err != nil {
 		log.Error("error in get rewards from db: ", zap.Error(err))
-		goodsWithReward.OrderNumber = 0
+		goodsWithReward.OrderNumber = ""
 		return
 	}
 	ordersWithGoods, err := s.GetAllOrdersAndGoods(ctx)
 	if err != nil {
 		log.Error("error in get orders from db: ", zap.Error(err))
-		goodsWithReward.OrderNumber = 0
+		goodsWithReward.OrderNumber = ""
 		return
 	}
 	goodsWithReward.Reward = rewards
 	for i := 0; i < len(ordersWithGoods); i++ {
-		if ordersWithGoods[i].StatusOrder != models.ProcessedOrder || ordersWithGoods[i].StatusOrder != models.InvalidOrder {
-			err := s.LoadAccrualStatusOrder(ctx, models.ProcessingOrder, goodsWithReward.OrderForRegister.OrderNumber, 0)
+		if ordersWithGoods[i].StatusOrder != models.ProcessedOrder && ordersWithGoods[i].StatusOrder != models.InvalidOrder {
+			err := s.LoadAccrualStatusOrder(ctx, models.ProcessingOrder, string(ordersWithGoods[i].OrderNumber), 0)
 			if err != nil {
 				log.Error("error in add orders from db: ", zap.Error(err))
 				return
@@ -65,16 +66,16 @@ func OrdersGoodsGorutine(ctx context.Context, ordersChan chan models.GoodsWithRe
 
 func resultAccrual(ctx context.Context, orderAndRewardChan chan models.GoodsWithReward, s storage.DBInterfaceOrdersAccrual, log *zap.Logger) {
 
-	var accraulSum int64 = 0
+	var accraulSum amount.Amount = 0
 	orderAndReward := <-orderAndRewardChan
-	if orderAndReward.OrderNumber == 0 {
+	if orderAndReward.OrderNumber == "" {
 		return
 	}
 	for _, reward := range orderAndReward.Reward {
 		for _, goods := range orderAndReward.Goods {
 			matched, err := regexp.MatchString(reward.Match, goods.Description)
 			if err != nil {
-				err := s.LoadAccrualStatusOrder(ctx, models.InvalidOrder, orderAndReward.OrderNumber, 0)
+				err := s.LoadAccrualStatusOrder(ctx, models.InvalidOrder, string(orderAndReward.OrderNumber), 0)
 				if err != nil {
 					log.Error("error in add orders from db: ", zap.Error(err))
 					return
@@ -83,12 +84,16 @@ func resultAccrual(ctx context.Context, orderAndRewardChan chan models.GoodsWith
 				return
 			}
 			if matched {
-				accrualOne := accrualCalculate(reward.RewardType, goods.Price, reward.Reward)
+				accrualOne, err := accrualCalculate(reward.RewardType, goods.Price, reward.Reward)
+				if err != nil {
+					log.Error("error in calculate accrual: ", zap.Error(err))
+					return
+				}
 				accraulSum += accrualOne
 			}
 		}
 	}
-	err := s.LoadAccrualStatusOrder(ctx, models.ProcessedOrder, orderAndReward.OrderNumber, accraulSum)
+	err := s.LoadAccrualStatusOrder(ctx, models.ProcessedOrder, string(orderAndReward.OrderNumber), accraulSum)
 	if err != nil {
 		log.Error("error in add orders from db: ", zap.Error(err))
 		return
@@ -96,16 +101,11 @@ func resultAccrual(ctx context.Context, orderAndRewardChan chan models.GoodsWith
 
 }
 
-func accrualCalculate(rewardType string, price, Reward int64) int64 {
-	accrual := 0.00
+// начисление за товар: процент от цены или фиксированная сумма,
+// процент округляется до сотых в сторону нуля (см. пакет amount)
+func accrualCalculate(rewardType string, price, reward amount.Amount) (amount.Amount, error) {
 	if rewardType == models.RewardTypeDefault {
-		rewardToFloat64 := float64(Reward)
-		priceFloat64 := float64(price)
-		sum := rewardToFloat64 * priceFloat64 / 100
-		accrual += sum
-	} else {
-		return Reward
+		return amount.Percent(price, reward)
 	}
-	accrualToInt64 := int64(accrual)
-	return accrualToInt64
+	return reward, nil
 }
@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
 
 	"github.com/MlDenis/internal/accrual/models"
 	"github.com/MlDenis/internal/luna"
+	"github.com/MlDenis/internal/ordernumber"
 	"github.com/MlDenis/pkg"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -33,10 +33,18 @@ func (m *HandlerDB) RegisterNewOrder(ctx context.Context, log *zap.Logger) http.
 		dec := json.NewDecoder(req.Body)
 		if err := dec.Decode(jsonOrder); err != nil {
 			log.Error("cannot decode request JSON body", zap.Error(err))
-			res.WriteHeader(http.StatusInternalServerError)
+			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		validNumber := luna.Valid(jsonOrder.OrderNumber)
+		orderID := string(jsonOrder.OrderNumber)
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		orderID = luna.Normalize(orderID)
+		jsonOrder.OrderNumber = ordernumber.Number(orderID)
+		validNumber := luna.Valid(orderID)
 		if !validNumber {
 			log.Error("invalid order number")
 			res.WriteHeader(http.StatusUnprocessableEntity)
@@ -73,12 +81,13 @@ func (m *HandlerDB) GetOrder(ctx context.Context, log *zap.Logger) http.HandlerF
 		}
 		number := chi.URLParam(req, "number")
 		//Проверяем на алгоритм луна
-		orderID, err := strconv.ParseInt(string(number), 10, 64)
-		if err != nil {
-			log.Error("wrong order number:", zap.Error(err))
+		orderID := number
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		orderID = luna.Normalize(orderID)
 		validNumber := luna.Valid(orderID)
 		if !validNumber {
 			log.Error("invalid order number")
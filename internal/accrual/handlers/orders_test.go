@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MlDenis/internal/accrual/models"
+	"github.com/MlDenis/internal/accrual/storage"
+	"go.uber.org/zap"
+)
+
+// хранилище, которое запоминает зарегистрированный заказ
+type registerOrderStorage struct {
+	storage.DBInterfaceOrdersAccrual
+	order *models.OrderForRegister
+}
+
+func (s *registerOrderStorage) LoadOrderInOrdersAccrualDB(ctx context.Context, order *models.OrderForRegister) error {
+	s.order = order
+	return nil
+}
+
+// номер заказа принимается и строкой, и числом, как его присылали до перехода на строки,
+// ведущие нули отбрасываются, как при разборе числа
+func TestRegisterNewOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "numeric order number", body: `{"order_number": 12345678903, "goods": []}`, want: http.StatusAccepted},
+		{name: "string order number", body: `{"order_number": "12345678903", "goods": []}`, want: http.StatusAccepted},
+		{name: "leading zeros", body: `{"order_number": "0012345678903", "goods": []}`, want: http.StatusAccepted},
+		{name: "fractional order number", body: `{"order_number": 12345678903.5, "goods": []}`, want: http.StatusBadRequest},
+		{name: "broken body", body: `{"order_number": `, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &registerOrderStorage{}
+			m := HandlerNew(s)
+			req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			res := httptest.NewRecorder()
+
+			m.RegisterNewOrder(context.Background(), zap.NewNop())(res, req)
+
+			if res.Code != tt.want {
+				t.Fatalf("status = %d, want %d", res.Code, tt.want)
+			}
+			if tt.want == http.StatusAccepted && (s.order == nil || s.order.OrderNumber != "12345678903") {
+				t.Errorf("registered order = %+v, want number 12345678903", s.order)
+			}
+		})
+	}
+}
@@ -1,22 +1,28 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/ordernumber"
+)
+
+// номер заказа в JSON принимаем и строкой, и числом, как присылали клиенты до перехода на строки
 type Order struct {
-	OrderNumber int64  `json:"order_number"`
-	StatusOrder string `json:"status_order"`
-	Accrual     int64  `json:"accrual"`
+	OrderNumber ordernumber.Number `json:"order_number"`
+	StatusOrder string             `json:"status_order"`
+	Accrual     amount.Amount      `json:"accrual"`
 }
 
 type OrderForRegister struct {
-	OrderNumber int64   `json:"order_number"`
-	StatusOrder string  `json:"status_order"`
-	Goods       []Goods `json:"goods"`
+	OrderNumber ordernumber.Number `json:"order_number"`
+	StatusOrder string             `json:"status_order"`
+	Goods       []Goods            `json:"goods"`
 }
 
 type Goods struct {
-	Description string `json:"description"`
-	Price       int64  `json:"price"`
+	Description string        `json:"description"`
+	Price       amount.Amount `json:"price"`
 }
 
 type GoodsWithReward struct {
@@ -24,9 +30,9 @@ type GoodsWithReward struct {
 	OrderForRegister
 }
 type Reward struct {
-	Match      string `json:"match"`
-	Reward     int64  `json:"reward"`
-	RewardType string `json:"reward_type"`
+	Match      string        `json:"match"`
+	Reward     amount.Amount `json:"reward"`
+	RewardType string        `json:"reward_type"`
 }
 
 const (
@@ -4,16 +4,17 @@ import (
 	"context"
 
 	"github.com/MlDenis/internal/accrual/models"
+	"github.com/MlDenis/internal/amount"
 	"go.uber.org/zap"
 )
 
 type DBInterfaceOrdersAccrual interface {
-	GetOrderFromOrdersAccrualDB(ctx context.Context, ordernumber int64) (*models.Order, error)
+	GetOrderFromOrdersAccrualDB(ctx context.Context, ordernumber string) (*models.Order, error)
 	LoadOrderInOrdersAccrualDB(ctx context.Context, order *models.OrderForRegister) error
 	RegisterInfoInDB(ctx context.Context, goods *models.Reward) error
 	// AddGoods(ctx context.Context, orderForRegister *models.OrderForRegister) error
 	// GetAllGoods(ctx context.Context, orders *models.OrderForRegister) ([]models.GoodsWithReward, error)
-	LoadAccrualStatusOrder(ctx context.Context, status string, ordernumber, accraul int64) error
+	LoadAccrualStatusOrder(ctx context.Context, status string, ordernumber string, accraul amount.Amount) error
 	GetAllOrdersAndGoods(ctx context.Context) ([]models.OrderForRegister, error)
 	GetAllRewards(ctx context.Context) ([]models.Reward, error)
 }
@@ -4,10 +4,11 @@ import (
 	"context"
 
 	"github.com/MlDenis/internal/accrual/models"
+	"github.com/MlDenis/internal/amount"
 )
 
 // Получение баланса пользователя
-func (pgdb *PostgresDB) GetOrderFromOrdersAccrualDB(ctx context.Context, ordernumber int64) (*models.Order, error) {
+func (pgdb *PostgresDB) GetOrderFromOrdersAccrualDB(ctx context.Context, ordernumber string) (*models.Order, error) {
 	tx, err := pgdb.pool.Begin(ctx)
 	if err != nil {
 
@@ -47,7 +48,7 @@ func (pgdb *PostgresDB) LoadOrderInOrdersAccrualDB(ctx context.Context, orderFor
 }
 
 // добавление aacrual
-func (pgdb *PostgresDB) LoadAccrualStatusOrder(ctx context.Context, status string, ordernumber int64, accraul int64) error {
+func (pgdb *PostgresDB) LoadAccrualStatusOrder(ctx context.Context, status string, ordernumber string, accraul amount.Amount) error {
 	tx, err := pgdb.pool.Begin(ctx)
 	if err != nil {
 
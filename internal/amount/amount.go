@@ -0,0 +1,166 @@
+// Package amount - суммы баллов и цен с фиксированной точкой.
+//
+// Сумма хранится целым числом сотых долей (минорных единиц): 500.5 балла = 50050.
+// В JSON сумма пишется и читается десятичным числом без float: 500.5, 751.25, 42.
+//
+// Правила округления:
+//   - на входе допускается не больше двух знаков после точки, более точные значения
+//     отклоняются с ErrPrecision, а не округляются;
+//   - при расчете процента (Percent) результат округляется до сотых в сторону нуля,
+//     то есть доли меньше 0.01 балла не начисляются.
+package amount
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount - сумма в сотых долях
+type Amount int64
+
+// число минорных единиц в одной целой
+const Scale = 100
+
+// число знаков после точки
+const Precision = 2
+
+var (
+	ErrSyntax    = errors.New("amount: invalid decimal")
+	ErrPrecision = errors.New("amount: more than 2 fractional digits")
+	ErrRange     = errors.New("amount: value out of range")
+)
+
+// сумма из целого числа баллов
+func FromInt(units int64) Amount {
+	return Amount(units * Scale)
+}
+
+// разбор десятичной строки вида 751, -0.5, 500.25
+func Parse(s string) (Amount, error) {
+	if s == "" {
+		return 0, ErrSyntax
+	}
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" || (hasDot && fracPart == "") || !digitsOnly(intPart) || !digitsOnly(fracPart) {
+		return 0, ErrSyntax
+	}
+	if len(fracPart) > Precision {
+		// нули в конце точность не добавляют: 1.500 = 1.50
+		trimmed := strings.TrimRight(fracPart[Precision:], "0")
+		if trimmed != "" {
+			return 0, ErrPrecision
+		}
+		fracPart = fracPart[:Precision]
+	}
+	fracPart += strings.Repeat("0", Precision-len(fracPart))
+
+	units, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, ErrRange
+	}
+	frac, _ := strconv.ParseInt(fracPart, 10, 64)
+	if units > (math.MaxInt64-frac)/Scale {
+		return 0, ErrRange
+	}
+	value := units*Scale + frac
+	if negative {
+		value = -value
+	}
+	return Amount(value), nil
+}
+
+func digitsOnly(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// десятичная запись без лишних нулей: 50050 -> "500.5", 4200 -> "42"
+func (a Amount) String() string {
+	value := int64(a)
+	sign := ""
+	if value < 0 {
+		sign = "-"
+	}
+	abs := new(big.Int).Abs(big.NewInt(value))
+	units, frac := new(big.Int).QuoRem(abs, big.NewInt(Scale), new(big.Int))
+	if frac.Sign() == 0 {
+		return sign + units.String()
+	}
+	fracStr := fmt.Sprintf("%0*d", Precision, frac.Int64())
+	return sign + units.String() + "." + strings.TrimRight(fracStr, "0")
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// принимаем число или строку с числом в кавычках, остальное - ошибка
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return ErrSyntax
+		}
+	}
+	// экспоненциальная запись в суммах не используется
+	if strings.ContainsAny(s, "eE") {
+		return ErrSyntax
+	}
+	value, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = value
+	return nil
+}
+
+// в бд сумма хранится целым числом минорных единиц (BIGINT)
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case int64:
+		*a = Amount(v)
+	case int32:
+		*a = Amount(v)
+	default:
+		return fmt.Errorf("amount: cannot scan %T", src)
+	}
+	return nil
+}
+
+// процент от суммы: percent тоже Amount, 12.5% = 1250.
+// Результат округляется до сотых в сторону нуля
+func Percent(base, percent Amount) (Amount, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(base)), big.NewInt(int64(percent)))
+	product.Quo(product, big.NewInt(100*Scale))
+	if !product.IsInt64() {
+		return 0, ErrRange
+	}
+	return Amount(product.Int64()), nil
+}
@@ -0,0 +1,161 @@
+package amount
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr error
+	}{
+		{in: "751", want: 75100},
+		{in: "500.25", want: 50025},
+		{in: "500.5", want: 50050},
+		{in: "-0.5", want: -50},
+		{in: "+1.25", want: 125},
+		{in: "0", want: 0},
+		{in: "1.500", want: 150},
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "1.505", wantErr: ErrPrecision},
+		{in: "0.001", wantErr: ErrPrecision},
+		{in: "", wantErr: ErrSyntax},
+		{in: "-", wantErr: ErrSyntax},
+		{in: ".5", wantErr: ErrSyntax},
+		{in: "5.", wantErr: ErrSyntax},
+		{in: "1e3", wantErr: ErrSyntax},
+		{in: "--1", wantErr: ErrSyntax},
+		{in: "1,5", wantErr: ErrSyntax},
+		{in: " 1", wantErr: ErrSyntax},
+		{in: "92233720368547758.08", wantErr: ErrRange},
+		{in: "99999999999999999999", wantErr: ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{in: 0, want: "0"},
+		{in: 4200, want: "42"},
+		{in: 50050, want: "500.5"},
+		{in: 50025, want: "500.25"},
+		{in: 5, want: "0.05"},
+		{in: -50, want: "-0.5"},
+		{in: -12345, want: "-123.45"},
+		{in: -4200, want: "-42"},
+		{in: math.MaxInt64, want: "92233720368547758.07"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, in := range []Amount{0, 5, 50050, 4200, -12345, math.MaxInt64} {
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal(%d): %v", int64(in), err)
+		}
+		var got Amount
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if got != in {
+			t.Errorf("round trip of %d via %s = %d", int64(in), data, int64(got))
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: `500.5`, want: 50050},
+		{in: `"500.5"`, want: 50050},
+		{in: `-42`, want: -4200},
+		{in: `null`, want: 0},
+		{in: `"12`, wantErr: true},
+		{in: `12"`, wantErr: true},
+		{in: `""`, wantErr: true},
+		{in: `"abc"`, wantErr: true},
+		{in: `1e3`, wantErr: true},
+		{in: `"1e3"`, wantErr: true},
+		{in: `1.005`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Amount
+		err := got.UnmarshalJSON([]byte(tt.in))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("UnmarshalJSON(%s) = %d, want error", tt.in, int64(got))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("UnmarshalJSON(%s) unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("UnmarshalJSON(%s) = %d, want %d", tt.in, int64(got), int64(tt.want))
+		}
+	}
+}
+
+// процент округляется до сотых в сторону нуля, доли меньше 0.01 не начисляются
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		base, percent Amount
+		want          Amount
+		wantErr       error
+	}{
+		{base: FromInt(100), percent: FromInt(10), want: FromInt(10)},
+		{base: 12345, percent: 1250, want: 1543},
+		{base: 199, percent: FromInt(50), want: 99},
+		{base: 1, percent: FromInt(50), want: 0},
+		{base: -12345, percent: 1250, want: -1543},
+		{base: 0, percent: FromInt(10), want: 0},
+		{base: math.MaxInt64, percent: FromInt(200), wantErr: ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Percent(tt.base, tt.percent)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Percent(%s, %s) error = %v, want %v", tt.base, tt.percent, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Percent(%s, %s) unexpected error: %v", tt.base, tt.percent, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Percent(%s, %s) = %s, want %s", tt.base, tt.percent, got, tt.want)
+		}
+	}
+}
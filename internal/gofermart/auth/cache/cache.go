@@ -1,38 +1,160 @@
 package cache
 
 import (
-	"sync"
+	"context"
+	"time"
 
+	"github.com/MlDenis/internal/gofermart/auth"
 	"github.com/MlDenis/internal/gofermart/models"
 	"github.com/MlDenis/pkg"
+	"go.uber.org/zap"
 )
 
-// структура где будут хранится токены в оперативной памяти
+// хранилище сессий, реализуется в памяти (MemoryStore) и в PostgreSQL (storage.PostgresDB)
+type Store interface {
+	AddSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, token string) (*models.Session, error)
+	DeleteExpiredSessions(ctx context.Context) error
+	RevokeToken(ctx context.Context, token models.RevokedToken) error
+	RevokeAllTokens(ctx context.Context, login, exceptSessionID string) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	AddRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, tokenHash string) (bool, error)
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+}
+
+// структура для работы с токенами пользователей поверх хранилища сессий
 type DataJWT struct {
-	data map[string]string
-	mu   sync.RWMutex
+	store      Store
+	ttl        time.Duration
+	refreshTTL time.Duration
 }
 
-func NewDataJWT() *DataJWT {
+// ttl и refreshTTL должны совпадать со временем жизни токенов (auth.TOKEN_EXP и auth.REFRESH_TOKEN_EXP)
+func NewDataJWT(store Store, ttl, refreshTTL time.Duration) *DataJWT {
 	return &DataJWT{
-		data: map[string]string{},
+		store:      store,
+		ttl:        ttl,
+		refreshTTL: refreshTTL,
 	}
 }
 
-// добавляем токен в нашу структуру
-func (userJWT *DataJWT) AddToken(userData *models.UserData) {
-	userJWT.mu.Lock()
-	defer userJWT.mu.Unlock()
-	userJWT.data[userData.Token] = userData.Login
-
+// добавляем токен в хранилище сессий
+func (userJWT *DataJWT) AddToken(ctx context.Context, userData *models.UserData) error {
+	return userJWT.store.AddSession(ctx, models.Session{
+		Token:     userData.Token,
+		TokenID:   userData.TokenID,
+		SessionID: userData.SessionID,
+		Login:     userData.Login,
+		ExpiresAt: time.Now().Add(userJWT.ttl),
+	})
 }
 
 // получаем токен(для проверки авторизации)
-func (userJWT *DataJWT) GetToken(userData *models.UserData) error {
-	login, ok := userJWT.data[userData.Token]
-	if !ok {
-		return pkg.TokenNotExist
+func (userJWT *DataJWT) GetToken(ctx context.Context, userData *models.UserData) error {
+	session, err := userJWT.store.GetSession(ctx, userData.Token)
+	if err != nil {
+		return err
 	}
-	userData.Login = login
+	userData.Login = session.Login
 	return nil
 }
+
+// проверяем, не отозван ли токен с этим идентификатором
+func (userJWT *DataJWT) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	return userJWT.store.IsTokenRevoked(ctx, tokenID)
+}
+
+// отзываем один токен (выход из текущей сессии)
+func (userJWT *DataJWT) Revoke(ctx context.Context, login, tokenID string, expiresAt time.Time) error {
+	return userJWT.store.RevokeToken(ctx, models.RevokedToken{
+		TokenID:   tokenID,
+		Login:     login,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// отзываем все токены пользователя (выход со всех устройств)
+func (userJWT *DataJWT) RevokeAll(ctx context.Context, login string) error {
+	return userJWT.store.RevokeAllTokens(ctx, login, "")
+}
+
+// отзываем все токены пользователя, кроме токенов сессии exceptSessionID
+func (userJWT *DataJWT) RevokeOthers(ctx context.Context, login, exceptSessionID string) error {
+	return userJWT.store.RevokeAllTokens(ctx, login, exceptSessionID)
+}
+
+// выпускаем новый refresh-токен в семействе familyID
+func (userJWT *DataJWT) IssueRefreshToken(ctx context.Context, login, familyID string) (string, error) {
+	token, err := auth.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = userJWT.store.AddRefreshToken(ctx, models.RefreshToken{
+		TokenHash: auth.HashRefreshToken(token),
+		FamilyID:  familyID,
+		Login:     login,
+		ExpiresAt: time.Now().Add(userJWT.refreshTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// используем refresh-токен для ротации, повторное использование отзывает все семейство
+func (userJWT *DataJWT) UseRefreshToken(ctx context.Context, token string) (*models.RefreshToken, error) {
+	tokenHash := auth.HashRefreshToken(token)
+	refresh, err := userJWT.store.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if refresh.Revoked || !refresh.ExpiresAt.After(time.Now()) {
+		return nil, pkg.RefreshTokenInvalid
+	}
+	if refresh.Used {
+		return nil, userJWT.reuseDetected(ctx, refresh)
+	}
+	//помечаем токен использованным атомарно, чтобы два параллельных запроса не получили новую пару
+	marked, err := userJWT.store.MarkRefreshTokenUsed(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		return nil, userJWT.reuseDetected(ctx, refresh)
+	}
+	return refresh, nil
+}
+
+// отзываем все refresh-токены семейства (сессии)
+func (userJWT *DataJWT) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	if familyID == "" {
+		return nil
+	}
+	return userJWT.store.RevokeRefreshFamily(ctx, familyID)
+}
+
+// refresh-токен украден или клиент ошибся: семейство больше не действует
+func (userJWT *DataJWT) reuseDetected(ctx context.Context, refresh *models.RefreshToken) error {
+	err := userJWT.store.RevokeRefreshFamily(ctx, refresh.FamilyID)
+	if err != nil {
+		return err
+	}
+	return pkg.RefreshTokenReused
+}
+
+// в фоне удаляем просроченные сессии, отозванные и refresh-токены
+func (userJWT *DataJWT) RunEviction(ctx context.Context, interval time.Duration, log *zap.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			err := userJWT.store.DeleteExpiredSessions(ctx)
+			if err != nil {
+				log.Error("error in delete expired sessions: ", zap.Error(err))
+			}
+		}
+	}
+}
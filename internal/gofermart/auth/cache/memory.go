@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+)
+
+// структура где будут хранится токены в оперативной памяти, подходит для тестов и одного экземпляра сервиса
+type MemoryStore struct {
+	data    map[string]models.Session
+	revoked map[string]models.RevokedToken
+	refresh map[string]models.RefreshToken
+	mu      sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data:    map[string]models.Session{},
+		revoked: map[string]models.RevokedToken{},
+		refresh: map[string]models.RefreshToken{},
+	}
+}
+
+// добавляем сессию в память
+func (m *MemoryStore) AddSession(ctx context.Context, session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[session.Token] = session
+	return nil
+}
+
+// получаем сессию, просроченные считаем отсутствующими
+func (m *MemoryStore) GetSession(ctx context.Context, token string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	session, ok := m.data[token]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, pkg.TokenNotExist
+	}
+	return &session, nil
+}
+
+// удаляем просроченные сессии и отозванные токены, срок которых уже истек
+func (m *MemoryStore) DeleteExpiredSessions(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for token, session := range m.data {
+		if !session.ExpiresAt.After(now) {
+			delete(m.data, token)
+		}
+	}
+	for tokenID, revoked := range m.revoked {
+		if !revoked.ExpiresAt.After(now) {
+			delete(m.revoked, tokenID)
+		}
+	}
+	for tokenHash, refresh := range m.refresh {
+		if !refresh.ExpiresAt.After(now) {
+			delete(m.refresh, tokenHash)
+		}
+	}
+	return nil
+}
+
+// отзываем токен и удаляем его сессию
+func (m *MemoryStore) RevokeToken(ctx context.Context, revoked models.RevokedToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[revoked.TokenID] = revoked
+	for token, session := range m.data {
+		if session.TokenID == revoked.TokenID {
+			delete(m.data, token)
+		}
+	}
+	return nil
+}
+
+// отзываем все токены пользователя, включая refresh-токены, кроме сессии exceptSessionID
+func (m *MemoryStore) RevokeAllTokens(ctx context.Context, login, exceptSessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, session := range m.data {
+		if session.Login == login && (exceptSessionID == "" || session.SessionID != exceptSessionID) {
+			m.revoked[session.TokenID] = models.RevokedToken{
+				TokenID:   session.TokenID,
+				Login:     session.Login,
+				ExpiresAt: session.ExpiresAt,
+			}
+			delete(m.data, token)
+		}
+	}
+	for tokenHash, refresh := range m.refresh {
+		if refresh.Login == login && (exceptSessionID == "" || refresh.FamilyID != exceptSessionID) {
+			refresh.Revoked = true
+			m.refresh[tokenHash] = refresh
+		}
+	}
+	return nil
+}
+
+// проверяем, есть ли токен в списке отозванных
+func (m *MemoryStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.revoked[tokenID]
+	return ok, nil
+}
+
+// сохраняем refresh-токен
+func (m *MemoryStore) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refresh[token.TokenHash] = token
+	return nil
+}
+
+// получаем refresh-токен по хэшу
+func (m *MemoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	refresh, ok := m.refresh[tokenHash]
+	if !ok {
+		return nil, pkg.RefreshTokenInvalid
+	}
+	return &refresh, nil
+}
+
+// помечаем refresh-токен использованным, false если его уже использовали
+func (m *MemoryStore) MarkRefreshTokenUsed(ctx context.Context, tokenHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refresh, ok := m.refresh[tokenHash]
+	if !ok || refresh.Used {
+		return false, nil
+	}
+	refresh.Used = true
+	m.refresh[tokenHash] = refresh
+	return true, nil
+}
+
+// отзываем все refresh-токены семейства
+func (m *MemoryStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tokenHash, refresh := range m.refresh {
+		if refresh.FamilyID == familyID {
+			refresh.Revoked = true
+			m.refresh[tokenHash] = refresh
+		}
+	}
+	return nil
+}
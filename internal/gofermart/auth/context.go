@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/MlDenis/internal/gofermart/models"
+)
+
+type contextKey struct{}
+
+// кладем проверенные утверждения токена в контекст запроса
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// достаем утверждения токена из контекста запроса
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// роль аутентифицированного пользователя, в старых токенах роли нет - это обычный пользователь
+func RoleFromContext(ctx context.Context) string {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if claims.Role == "" {
+		return models.RoleUser
+	}
+	return claims.Role
+}
+
+// логин аутентифицированного пользователя, пустая строка если его нет
+func LoginFromContext(ctx context.Context) string {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return claims.Username
+}
@@ -2,15 +2,97 @@ package auth
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 )
 
-const SECRET_KEY = "secret"
+// постоянный ключ старого HMAC-хэширования паролей, для подписи токенов не используется
+const legacySecretKey = "secret"
+
+// параметры argon2id, при их изменении старые хэши пересчитываются при входе пользователя
+const (
+	argonTime    uint32 = 1
+	argonMemory  uint32 = 64 * 1024
+	argonThreads uint8  = 4
+	argonKeyLen  uint32 = 32
+	argonSaltLen        = 16
+)
+
+// хэшируем пароль для последующей ей записи в бд, соль и параметры хранятся вместе с хэшем:
+// $argon2id$v=19$m=65536,t=1,p=4$<соль>$<хэш>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// проверяем пароль, needsRehash говорит о том, что хэш надо пересчитать с текущими параметрами
+func VerifyPassword(password, encodedHash string) (ok bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(encodedHash, "$argon2id$") {
+		//старые пароли хранились как HMAC-SHA256 с постоянным ключом
+		legacy := legacyHashPassword(password)
+		return hmac.Equal([]byte(legacy), []byte(encodedHash)), true, nil
+	}
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return false, false, fmt.Errorf("invalid password hash format")
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid password hash version: %w", err)
+	}
+	if version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+	var memory, time uint32
+	var threads uint8
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid password hash params: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid password hash: %w", err)
+	}
+	otherKey := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+	needsRehash = memory != argonMemory || time != argonTime || threads != argonThreads || uint32(len(key)) != argonKeyLen
+	return true, needsRehash, nil
+}
+
+// постоянный хэш с текущими параметрами argon2id, пароль к нему не используется
+const dummyHash = "$argon2id$v=19$m=65536,t=1,p=4$90nNij9mPrZlsq78/1M0mA$3uehMeooK4/8viWljNbSBjNmsuUaIybdy9XoUdkv2qQ"
+
+// проверяем пароль против постоянного хэша, когда пользователь не найден: вход в несуществующий
+// аккаунт занимает столько же времени, сколько неверный пароль, и по времени ответа нельзя узнать, есть ли логин
+func VerifyDummyPassword(password string) {
+	VerifyPassword(password, dummyHash)
+}
 
-// хэшируем пароль для последующей ей записи в бд
-func HashPassword(data string) (hash string) {
-	key := []byte(SECRET_KEY)
+// старый способ хэширования, нужен только для проверки паролей, сохраненных до перехода на argon2id
+func legacyHashPassword(data string) (hash string) {
+	key := []byte(legacySecretKey)
 	h := hmac.New(sha256.New, key)
 	h.Write([]byte(data))
 	hash = fmt.Sprintf("%x", h.Sum(nil))
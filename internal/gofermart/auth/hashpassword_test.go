@@ -0,0 +1,15 @@
+package auth
+
+import "testing"
+
+// постоянный хэш должен считаться с текущими параметрами, иначе проверка для
+// несуществующего логина займет другое время, чем для существующего
+func TestDummyHashUsesCurrentParams(t *testing.T) {
+	ok, needsRehash, err := VerifyPassword("dummy password", dummyHash)
+	if err != nil || !ok {
+		t.Fatalf("VerifyPassword(dummyHash) = %v, %v", ok, err)
+	}
+	if needsRehash {
+		t.Error("dummyHash was computed with outdated argon2id parameters")
+	}
+}
@@ -1,33 +1,133 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// токен будет жить 12 часов
-const TOKEN_EXP = time.Hour * 12
+// access-токен живет 15 минут, дальше клиент обновляет его по refresh-токену
+const TOKEN_EXP = time.Minute * 15
+
+// refresh-токен живет 30 дней
+const REFRESH_TOKEN_EXP = time.Hour * 24 * 30
+
+// кто выпускает токены и для кого они предназначены
+const (
+	TokenIssuer   = "gophermart"
+	TokenAudience = "gophermart-api"
+	// токен после проверки пароля, который можно обменять только на сессию вместе с кодом 2FA
+	PreAuthAudience = "gophermart-2fa"
+)
+
+// сколько живет токен между первым и вторым шагом входа
+const PRE_AUTH_TOKEN_EXP = time.Minute * 5
 
 // структура для нашего токена, можно добавить условия при необходимости
 type Claims struct {
 	jwt.RegisteredClaims
 	Username string `json:"username"`
+	// идентификатор сессии, он же семейство refresh-токенов
+	SessionID string `json:"sid"`
+	// роль пользователя на момент выпуска токена
+	Role string `json:"role"`
+}
+
+// создаем токен активным ключом, вместе с ним возвращаем его идентификатор (jti) для отзыва
+func (ks *KeySet) CreateJwtToken(loginUser, role, sessionID string) (string, string, error) {
+	return ks.createToken(loginUser, role, sessionID, TokenAudience, TOKEN_EXP)
+}
+
+// создаем короткоживущий токен для второго шага входа с 2FA
+func (ks *KeySet) CreatePreAuthToken(loginUser string) (string, error) {
+	token, _, err := ks.createToken(loginUser, "", "", PreAuthAudience, PRE_AUTH_TOKEN_EXP)
+	return token, err
 }
 
-// создаем токен
-func CreateJwtToken(loginUser string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+func (ks *KeySet) createToken(loginUser, role, sessionID, audience string, exp time.Duration) (string, string, error) {
+	tokenID, err := NewTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	key := ks.keys[ks.active]
+	token := jwt.NewWithClaims(key.method, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       tokenID,
+			Issuer:   TokenIssuer,
+			Audience: jwt.ClaimStrings{audience},
 			// когда создан токен
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TOKEN_EXP)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(exp)),
 		},
 		// собственное утверждение
-		Username: loginUser,
+		Username:  loginUser,
+		SessionID: sessionID,
+		Role:      role,
 	})
-	tokenString, err := token.SignedString([]byte(SECRET_KEY))
+	//по kid проверяющая сторона найдет нужный ключ, пока идет ротация
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", "", err
+	}
+	return tokenString, tokenID, err
+}
+
+// разбираем токен и проверяем подпись, срок действия, издателя и получателя
+func (ks *KeySet) ParseJwtToken(tokenString string) (*Claims, error) {
+	return ks.parseToken(tokenString, TokenAudience)
+}
+
+// разбираем токен второго шага входа с 2FA
+func (ks *KeySet) ParsePreAuthToken(tokenString string) (*Claims, error) {
+	return ks.parseToken(tokenString, PreAuthAudience)
+}
+
+func (ks *KeySet) parseToken(tokenString, audience string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, ks.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(TokenIssuer, true) {
+		return nil, fmt.Errorf("unexpected token issuer: %s", claims.Issuer)
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return nil, fmt.Errorf("unexpected token audience: %v", claims.Audience)
+	}
+	if claims.Username == "" {
+		return nil, fmt.Errorf("token has no username")
+	}
+	//без идентификатора токен нельзя отозвать, такие токены не принимаем
+	if claims.ID == "" {
+		return nil, fmt.Errorf("token has no id")
+	}
+	return claims, nil
+}
+
+// ищем ключ проверки по kid и сверяем алгоритм, чтобы нельзя было подменить его в заголовке
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// случайный идентификатор токена
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
 	if err != nil {
 		return "", err
 	}
-	return tokenString, err
+	return hex.EncodeToString(b), nil
 }
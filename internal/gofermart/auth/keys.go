@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// поддерживаемые алгоритмы подписи токенов
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// ключ подписи; ключ без закрытой части годится только для проверки старых токенов
+type SigningKey struct {
+	ID        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// набор ключей: активным подписываем новые токены, остальными только проверяем
+type KeySet struct {
+	keys   map[string]*SigningKey
+	active string
+}
+
+// описание ключа в файле конфигурации
+type keyConfig struct {
+	ID             string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// файл конфигурации ключей, пути к pem-файлам считаются от каталога самого файла
+type keysFileConfig struct {
+	Active string      `json:"active"`
+	Keys   []keyConfig `json:"keys"`
+}
+
+func NewKeySet(keys []*SigningKey, activeID string) (*KeySet, error) {
+	ks := &KeySet{
+		keys:   map[string]*SigningKey{},
+		active: activeID,
+	}
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key id: %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	active, ok := ks.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found", activeID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeID)
+	}
+	return ks, nil
+}
+
+// HS256 ключ из секрета
+func NewHMACKey(id, secret string) (*SigningKey, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("signing key %q: empty secret", id)
+	}
+	return &SigningKey{
+		ID:        id,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}, nil
+}
+
+// собираем набор ключей из файла и/или секрета HS256 из флагов, activeID пустой - берем из файла
+func LoadKeySet(keysFile, secret, activeID string) (*KeySet, error) {
+	keys := []*SigningKey{}
+	fileActive := ""
+	if keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read keys file: %w", err)
+		}
+		cfg := keysFileConfig{}
+		err = json.Unmarshal(data, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse keys file: %w", err)
+		}
+		for _, kc := range cfg.Keys {
+			key, err := loadKey(kc, filepath.Dir(keysFile))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		fileActive = cfg.Active
+	}
+	if secret != "" {
+		id := activeID
+		if id == "" {
+			id = "default"
+		}
+		key, err := NewHMACKey(id, secret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if fileActive == "" {
+			fileActive = id
+		}
+	}
+	if activeID == "" {
+		activeID = fileActive
+	}
+	return NewKeySet(keys, activeID)
+}
+
+// случайный HS256 ключ, если ключи не настроены: токены не переживут перезапуск сервиса
+func NewRandomKeySet() (*KeySet, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewHMACKey(kid, string(secret))
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet([]*SigningKey{key}, kid)
+}
+
+func loadKey(kc keyConfig, dir string) (*SigningKey, error) {
+	if kc.ID == "" {
+		return nil, fmt.Errorf("signing key without kid")
+	}
+	switch kc.Alg {
+	case AlgHS256:
+		return NewHMACKey(kc.ID, kc.Secret)
+	case AlgRS256, AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("signing key %q: unsupported alg %q", kc.ID, kc.Alg)
+	}
+	key := &SigningKey{ID: kc.ID}
+	if kc.PrivateKeyFile != "" {
+		pem, err := os.ReadFile(resolvePath(dir, kc.PrivateKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", kc.ID, err)
+		}
+		if kc.Alg == AlgRS256 {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %q: %w", kc.ID, err)
+			}
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		} else {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %q: %w", kc.ID, err)
+			}
+			key.signKey, key.verifyKey = private, private.(ed25519.PrivateKey).Public()
+		}
+	} else if kc.PublicKeyFile != "" {
+		pem, err := os.ReadFile(resolvePath(dir, kc.PublicKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", kc.ID, err)
+		}
+		if kc.Alg == AlgRS256 {
+			key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		} else {
+			key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", kc.ID, err)
+		}
+	} else {
+		return nil, fmt.Errorf("signing key %q: no private_key_file or public_key_file", kc.ID)
+	}
+	if kc.Alg == AlgRS256 {
+		key.method = jwt.SigningMethodRS256
+	} else {
+		key.method = jwt.SigningMethodEdDSA
+	}
+	return key, nil
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// открытые ключи в формате JWKS, HS256 ключи секретные и наружу не отдаются
+func (ks *KeySet) JWKS() models.JWKSet {
+	set := models.JWKSet{Keys: []models.JWK{}}
+	for _, key := range ks.keys {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, models.JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: AlgRS256,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, models.JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: AlgEdDSA,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
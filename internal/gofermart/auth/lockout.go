@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// хранилище счетчиков неудачных попыток входа, общее для всех экземпляров сервиса
+type LoginAttemptStore interface {
+	GetLoginLock(ctx context.Context, key string) (time.Time, error)
+	RegisterLoginFailure(ctx context.Context, key string, resetBefore time.Time) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginFailures(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error
+}
+
+// защита от перебора паролей: после MaxAttempts неудач подряд ключ блокируется,
+// каждая следующая неудача удваивает блокировку (BaseDelay, 2*BaseDelay, ... до MaxDelay)
+type Lockout struct {
+	store         LoginAttemptStore
+	LoginAttempts int
+	IPAttempts    int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	FailureWindow time.Duration
+}
+
+func NewLockout(store LoginAttemptStore, loginAttempts, ipAttempts int, baseDelay, maxDelay time.Duration) *Lockout {
+	return &Lockout{
+		store:         store,
+		LoginAttempts: loginAttempts,
+		IPAttempts:    ipAttempts,
+		BaseDelay:     baseDelay,
+		MaxDelay:      maxDelay,
+		//счетчик неудач сбрасывается, если неудач не было дольше этого времени
+		FailureWindow: 24 * time.Hour,
+	}
+}
+
+func loginKey(login string) string { return "login:" + login }
+func ipKey(ip string) string       { return "ip:" + ip }
+
+// сколько ждать до следующей попытки входа, 0 - можно пробовать
+func (l *Lockout) Check(ctx context.Context, login, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, key := range []string{loginKey(login), ipKey(ip)} {
+		lockedUntil, err := l.store.GetLoginLock(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if wait := time.Until(lockedUntil); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, nil
+}
+
+// учитываем неудачную попытку для логина и адреса
+func (l *Lockout) Fail(ctx context.Context, login, ip string) error {
+	err := l.fail(ctx, loginKey(login), l.LoginAttempts)
+	if err != nil {
+		return err
+	}
+	return l.fail(ctx, ipKey(ip), l.IPAttempts)
+}
+
+func (l *Lockout) fail(ctx context.Context, key string, maxAttempts int) error {
+	failures, err := l.store.RegisterLoginFailure(ctx, key, time.Now().Add(-l.FailureWindow))
+	if err != nil {
+		return err
+	}
+	if failures < maxAttempts {
+		return nil
+	}
+	return l.store.LockLogin(ctx, key, time.Now().Add(l.delay(failures-maxAttempts)))
+}
+
+// экспоненциальная задержка с ограничением сверху
+func (l *Lockout) delay(step int) time.Duration {
+	delay := l.BaseDelay
+	for i := 0; i < step && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+	return delay
+}
+
+// успешный вход сбрасывает счетчик логина; счетчик адреса не сбрасываем,
+// иначе можно перебирать чужие пароли, время от времени входя в свой аккаунт
+func (l *Lockout) Success(ctx context.Context, login string) error {
+	return l.store.ResetLoginFailures(ctx, loginKey(login))
+}
+
+// в фоне удаляем счетчики, по которым давно не было неудач
+func (l *Lockout) RunEviction(ctx context.Context, interval time.Duration, log *zap.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			err := l.store.DeleteStaleLoginAttempts(ctx, time.Now().Add(-l.FailureWindow))
+			if err != nil {
+				log.Error("error in delete stale login attempts: ", zap.Error(err))
+			}
+		}
+	}
+}
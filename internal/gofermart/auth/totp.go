@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// параметры TOTP (RFC 6238), их понимает любое приложение-аутентификатор
+const (
+	TOTPIssuer = "Gophermart"
+	totpPeriod = 30
+	totpDigits = 6
+	// допускаем расхождение часов клиента на один шаг в каждую сторону
+	totpSkew = 1
+	// секрет длиной 160 бит, как рекомендует RFC 4226
+	totpSecretLen = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// новый секрет для TOTP в base32
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretLen)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// ссылка otpauth:// для QR-кода в приложении-аутентификаторе
+func TOTPURI(login, secret string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + login)
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", TOTPIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// проверяем код и возвращаем номер шага, которому он соответствует;
+// шаг нужно запомнить, чтобы один и тот же код нельзя было использовать дважды
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// HOTP (RFC 4226) для шага времени
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// одноразовые коды восстановления вида abcd-ef01-2345
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 6)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		h := hex.EncodeToString(b)
+		codes = append(codes, h[:4]+"-"+h[4:8]+"-"+h[8:])
+	}
+	return codes, nil
+}
+
+// коды восстановления храним только в виде хэша, регистр и дефисы не важны
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,228 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/luna"
+	"github.com/MlDenis/pkg"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// баланс любого пользователя
+func (m *HandlerAdminDB) GetUserBalance(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		login := chi.URLParam(req, "login")
+		ResponseBalance, err := m.StorageBalance.GetBalanceDB(ctx, login)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			log.Error("cannot get user's balance: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response, err := json.Marshal(ResponseBalance)
+		if err != nil {
+			log.Error("cannot marshal to json: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Add("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write(response)
+	}
+}
+
+// ручная корректировка баллов пользователя
+func (m *HandlerAdminDB) AdjustUserBalance(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := chi.URLParam(req, "login")
+		adjustReq := &models.AdjustBalanceRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(adjustReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if adjustReq.Sum == 0 {
+			log.Error("empty balance adjustment")
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err := m.StorageBalance.AdjustBalance(ctx, login, adjustReq.Sum, adjustReq.Reason)
+		if err != nil {
+			if errors.Is(err, pkg.UserNotExist) {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, pkg.InsufficientFunds) {
+				res.WriteHeader(http.StatusPaymentRequired)
+				return
+			}
+			log.Error("cannot adjust balance: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Info("balance adjusted",
+			zap.String("admin", auth.LoginFromContext(req.Context())),
+			zap.String("login", login),
+			zap.Stringer("sum", adjustReq.Sum),
+			zap.String("reason", adjustReq.Reason),
+		)
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// возврат баллов по списанию, например при отмене покупки
+func (m *HandlerAdminDB) ReverseWithdrawal(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		orderID := chi.URLParam(req, "number")
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		orderID = luna.Normalize(orderID)
+		//причина необязательна, тело может быть пустым
+		reverseReq := &models.ReverseWithdrawalRequest{}
+		if req.ContentLength != 0 {
+			dec := json.NewDecoder(req.Body)
+			if err := dec.Decode(reverseReq); err != nil {
+				log.Error("cannot decode request JSON body", zap.Error(err))
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		err := m.StorageBalance.ReverseWithdrawal(ctx, orderID, reverseReq.Reason)
+		if err != nil {
+			switch {
+			case errors.Is(err, pkg.WithdrawalNotFound):
+				res.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, pkg.WithdrawalAlreadyReversed):
+				res.WriteHeader(http.StatusConflict)
+			case errors.Is(err, pkg.UserNotExist):
+				//аккаунт удален, возвращать баллы некуда
+				res.WriteHeader(http.StatusGone)
+			default:
+				log.Error("cannot reverse withdrawal: ", zap.Error(err))
+				res.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		log.Info("withdrawal reversed",
+			zap.String("admin", auth.LoginFromContext(req.Context())),
+			zap.String("order", orderID),
+			zap.String("reason", reverseReq.Reason),
+		)
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// смена роли пользователя
+func (m *HandlerAdminDB) SetUserRole(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := chi.URLParam(req, "login")
+		roleReq := &models.SetRoleRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(roleReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch roleReq.Role {
+		case models.RoleUser, models.RoleSupport, models.RoleAdmin:
+		default:
+			log.Error("unknown role", zap.String("role", roleReq.Role))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err := m.StorageUsers.SetUserRole(ctx, login, roleReq.Role)
+		if err != nil {
+			if errors.Is(err, pkg.UserNotExist) {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			log.Error("cannot set user role: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		//роль записана в токенах: старые токены отзываем, новые при обновлении получат роль из бд.
+		//хранилище в бд отзывает их в транзакции смены роли, здесь - для хранилища в памяти
+		err = m.DataJWT.RevokeAll(ctx, login)
+		if err != nil {
+			log.Error("tokens not revoked: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Info("user role changed",
+			zap.String("admin", auth.LoginFromContext(req.Context())),
+			zap.String("login", login),
+			zap.String("role", roleReq.Role),
+		)
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// сверка материализованного баланса с журналом
+func (m *HandlerAdminDB) ReconcileLedger(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mismatches, err := m.StorageBalance.ReconcileLedger(ctx)
+		if err != nil {
+			log.Error("cannot reconcile ledger: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response, err := json.Marshal(mismatches)
+		if err != nil {
+			log.Error("cannot marshal to json: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Add("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write(response)
+	}
+}
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"github.com/MlDenis/internal/gofermart/auth/cache"
+	"github.com/MlDenis/internal/gofermart/storage"
+)
+
+// структура для служебных хэндлеров поддержки и администраторов
+type HandlerAdminDB struct {
+	StorageUsers   storage.InterfaceUser
+	StorageBalance storage.InterfaceBalance
+	DataJWT        *cache.DataJWT
+}
+
+func HandlerAdmin(users storage.InterfaceUser, balance storage.InterfaceBalance, DataJWT *cache.DataJWT) *HandlerAdminDB {
+	return &HandlerAdminDB{
+		StorageUsers:   users,
+		StorageBalance: balance,
+		DataJWT:        DataJWT,
+	}
+}
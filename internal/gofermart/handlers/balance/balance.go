@@ -6,7 +6,9 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/MlDenis/internal/gofermart/auth"
 	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/rules"
 	"github.com/MlDenis/internal/luna"
 	"github.com/MlDenis/pkg"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -21,16 +23,9 @@ func (m *HandlerBalanceDB) GetBalance(ctx context.Context, log *zap.Logger) http
 			res.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		jsonUsers := &models.UserData{}
-		jsonUsers.Token = req.Header.Get(models.HeaderHTTP)
-		err := m.DataJWT.GetToken(jsonUsers)
-		if err != nil {
-			log.Error("user not authenticated: ", zap.Error(err))
-			res.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+		login := auth.LoginFromContext(req.Context())
 
-		ResponseBalance, err := m.StorageBalance.GetBalanceDB(ctx, jsonUsers.Login)
+		ResponseBalance, err := m.StorageBalance.GetBalanceDB(ctx, login)
 		if err != nil {
 			log.Error("error in add orders in db: ", zap.Error(err))
 			res.WriteHeader(http.StatusBadRequest)
@@ -62,72 +57,51 @@ func (m *HandlerBalanceDB) WithdrawBalance(ctx context.Context, log *zap.Logger)
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		//Создадим структуру пользователя, чтобы записать токен и логин в него
-		jsonUsers := &models.UserData{}
-		jsonUsers.Token = req.Header.Get(models.HeaderHTTP)
-		err := m.DataJWT.GetToken(jsonUsers)
-		if err != nil {
-			log.Error("user not authenticated: ", zap.Error(err))
-			res.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+		login := auth.LoginFromContext(req.Context())
 		wisthdrawSum := &models.WithdrawOrder{}
 		// десериализуем запрос в структуру модели
 		log.Error("decoding request")
 		dec := json.NewDecoder(req.Body)
 		if err := dec.Decode(&wisthdrawSum); err != nil {
+			//тело не разобралось: битый JSON, сумма или номер не того вида - ошибка клиента
 			log.Error("cannot decode request JSON body", zap.Error(err))
-			res.WriteHeader(http.StatusInternalServerError)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		orderID := string(wisthdrawSum.Order)
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
+			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		orderID = luna.Normalize(orderID)
 		//проверям заказ через алгоритм луна
-		validNumber := luna.Valid(wisthdrawSum.Order)
+		validNumber := luna.Valid(orderID)
 		if !validNumber {
 			log.Error("invalid order number")
 			res.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
-		//Проверям баланс
-		ResponseBalance, err := m.StorageBalance.GetBalanceDB(ctx, jsonUsers.Login)
-		if err != nil {
-			log.Error("error in add orders in db: ", zap.Error(err))
-			res.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		//проверям хватает ли средств для списания
-		if ResponseBalance.AccrualSum < wisthdrawSum.Sum {
-			log.Error("insufficient funds to write off: ", zap.Error(err))
-			res.WriteHeader(http.StatusPaymentRequired)
-			return
-		}
-		//Создадим структуру заказов, чтобы записать их в бд
-		jsonOrders := &models.Orders{}
-		jsonOrders.OrderNumber = wisthdrawSum.Order
-		jsonOrders.UserLogin = jsonUsers.Login
-		jsonOrders.StatusOrder = models.WithdrawEnd
-		jsonOrders.Withdraw = wisthdrawSum.Sum
-		err = m.StorageBalance.LoadOrderInDB(ctx, jsonOrders)
+		//списываем одной операцией: проверка баланса, заказ на списание и проводка в одной транзакции
+		err := m.StorageBalance.WithdrawBalanceDB(ctx, login, orderID, wisthdrawSum.Sum)
 		if err != nil {
+			var violation *rules.Violation
+			if errors.As(err, &violation) {
+				writeViolation(res, log, violation)
+				return
+			}
+			if errors.Is(err, pkg.InsufficientFunds) {
+				log.Error("insufficient funds to write off: ", zap.Error(err))
+				res.WriteHeader(http.StatusPaymentRequired)
+				return
+			}
 			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				//Проверяем,не загружали ли этот заказ ранее(точно ли это ноывй заказ)
-				if pgErr.Code == pkg.UniqueViolationCode {
-					log.Error("cannot be debited for this order because this order has already been uploaded")
-					res.WriteHeader(http.StatusConflict)
-					return
-				}
-				log.Error("error in add orders in db: ", zap.Error(err))
-				res.WriteHeader(http.StatusBadRequest)
+			//Проверяем,не загружали ли этот заказ ранее(точно ли это ноывй заказ)
+			if errors.As(err, &pgErr) && pgErr.Code == pkg.UniqueViolationCode {
+				log.Error("cannot be debited for this order because this order has already been uploaded")
+				res.WriteHeader(http.StatusConflict)
 				return
 			}
-			log.Error("error in add orders in db: ", zap.Error(err))
-			res.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		//Изменим баланс после списания
-		err = m.StorageBalance.EditBalanceWithdraw(ctx, jsonOrders.UserLogin, wisthdrawSum.Sum)
-		if err != nil {
 			log.Error("balance change error: ", zap.Error(err))
 			res.WriteHeader(http.StatusInternalServerError)
 			return
@@ -146,16 +120,8 @@ func (m *HandlerBalanceDB) GetWithdrawals(ctx context.Context, log *zap.Logger)
 			res.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		userData := &models.UserData{}
-		userData.Token = req.Header.Get(models.HeaderHTTP)
-
-		err := m.DataJWT.GetToken(userData)
-		if err != nil {
-			log.Error("user not authenticated: ", zap.Error(err))
-			res.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		withdrawals, err := m.StorageBalance.GetWithdrawalsDB(ctx, userData.Login)
+		login := auth.LoginFromContext(req.Context())
+		withdrawals, err := m.StorageBalance.GetWithdrawalsDB(ctx, login)
 		if err != nil {
 			if errors.Is(err, pkg.NoOrders) {
 				res.WriteHeader(http.StatusNoContent)
@@ -184,3 +150,17 @@ func (m *HandlerBalanceDB) GetWithdrawals(ctx context.Context, log *zap.Logger)
 		return
 	}
 }
+
+// нарушение правил списания: 422 и описание правила в теле ответа
+func writeViolation(res http.ResponseWriter, log *zap.Logger, violation *rules.Violation) {
+	log.Error("withdrawal rejected by rules", zap.String("rule", violation.Rule))
+	response, err := json.Marshal(violation)
+	if err != nil {
+		log.Error("cannot marshal to json: ", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusUnprocessableEntity)
+	res.Write(response)
+}
@@ -0,0 +1,112 @@
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/handlers/cursor"
+	"github.com/MlDenis/internal/gofermart/models"
+	"go.uber.org/zap"
+)
+
+// размер страницы выписки по умолчанию и максимальный
+const (
+	historyDefaultLimit = 50
+	historyMaxLimit     = 500
+)
+
+// Выписка по баллам: все начисления и списания с остатком после каждой операции.
+// Параметры: from и to в RFC3339, limit, cursor из next_cursor предыдущей страницы
+func (m *HandlerBalanceDB) GetBalanceHistory(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		filter, err := historyFilterFromQuery(req)
+		if err != nil {
+			log.Error("bad balance history query: ", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit := filter.Limit
+		//берем на одну запись больше, чтобы понять, есть ли следующая страница
+		filter.Limit++
+		entries, err := m.StorageBalance.GetBalanceHistory(ctx, login, filter)
+		if err != nil {
+			log.Error("cannot get balance history: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+		history := models.BalanceHistoryResponse{Entries: entries}
+		if len(entries) > limit {
+			history.Entries = entries[:limit]
+			history.NextCursor = cursor.Encode(history.Entries[limit-1].CreatedAt, history.Entries[limit-1].ID)
+		}
+		//курсор строим по времени как в бд, клиенту отдаем его с зоной сервера
+		for i := range history.Entries {
+			history.Entries[i].CreatedAt = models.ServerTime(history.Entries[i].CreatedAt)
+		}
+		response, err := json.Marshal(history)
+		if err != nil {
+			log.Error("cannot marshal to json: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Add("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write(response)
+	}
+}
+
+// время операций хранится без зоны по часам сервера, поэтому границы периода переводим в локальное время
+func historyFilterFromQuery(req *http.Request) (models.BalanceHistoryFilter, error) {
+	query := req.URL.Query()
+	filter := models.BalanceHistoryFilter{Limit: historyDefaultLimit}
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, err
+		}
+		t = t.Local()
+		filter.From = &t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, err
+		}
+		t = t.Local()
+		filter.To = &t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return filter, errors.New("bad limit")
+		}
+		if n > historyMaxLimit {
+			n = historyMaxLimit
+		}
+		filter.Limit = n
+	}
+	if after := query.Get("cursor"); after != "" {
+		afterTime, afterID, err := cursor.Decode(after)
+		if err != nil {
+			return filter, err
+		}
+		filter.AfterTime = &afterTime
+		filter.AfterID = afterID
+	}
+	return filter, nil
+}
@@ -0,0 +1,132 @@
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/rules"
+	"github.com/MlDenis/internal/luna"
+	"github.com/MlDenis/pkg"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// Резервирование баллов под оплату заказа
+func (m *HandlerBalanceDB) CreateHold(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		holdReq := &models.HoldRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(holdReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if holdReq.Sum <= 0 {
+			log.Error("hold sum must be positive")
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		orderID := string(holdReq.Order)
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		orderID = luna.Normalize(orderID)
+		if !luna.Valid(orderID) {
+			log.Error("invalid order number")
+			res.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		hold, err := m.StorageBalance.CreateHold(ctx, login, orderID, holdReq.Sum)
+		if err != nil {
+			writeHoldError(res, log, err)
+			return
+		}
+		response, err := json.Marshal(hold)
+		if err != nil {
+			log.Error("cannot marshal to json: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Add("Content-Type", "application/json")
+		res.WriteHeader(http.StatusCreated)
+		res.Write(response)
+	}
+}
+
+// Списание зарезервированных баллов после успешной оплаты
+func (m *HandlerBalanceDB) CaptureHold(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		err := m.StorageBalance.CaptureHold(ctx, login, chi.URLParam(req, "id"))
+		if err != nil {
+			writeHoldError(res, log, err)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// Снятие резерва, если оплата не прошла
+func (m *HandlerBalanceDB) ReleaseHold(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		err := m.StorageBalance.ReleaseHold(ctx, login, chi.URLParam(req, "id"))
+		if err != nil {
+			writeHoldError(res, log, err)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+func writeHoldError(res http.ResponseWriter, log *zap.Logger, err error) {
+	var pgErr *pgconn.PgError
+	var violation *rules.Violation
+	switch {
+	case errors.As(err, &violation):
+		writeViolation(res, log, violation)
+	case errors.Is(err, pkg.HoldNotFound):
+		res.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, pkg.HoldNotActive):
+		res.WriteHeader(http.StatusConflict)
+	case errors.Is(err, pkg.InsufficientFunds):
+		res.WriteHeader(http.StatusPaymentRequired)
+	case errors.Is(err, pkg.OrderAlreadyUploaded), errors.Is(err, pkg.OrderUploadedByAnotherUser):
+		res.WriteHeader(http.StatusConflict)
+	case errors.As(err, &pgErr) && pgErr.Code == pkg.UniqueViolationCode:
+		//по заказу уже есть резерв или списание
+		log.Error("order already has a hold or a withdrawal")
+		res.WriteHeader(http.StatusConflict)
+	default:
+		log.Error("hold operation failed: ", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+	}
+}
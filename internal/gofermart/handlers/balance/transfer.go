@@ -0,0 +1,61 @@
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"go.uber.org/zap"
+)
+
+// Перевод баллов другому пользователю
+func (m *HandlerBalanceDB) TransferBalance(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		transfer := &models.TransferRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(transfer); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if transfer.To == "" || transfer.Sum <= 0 {
+			log.Error("bad transfer request", zap.String("to", transfer.To), zap.Stringer("sum", transfer.Sum))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err := m.StorageBalance.TransferBalance(ctx, login, transfer.To, transfer.Sum, transfer.Comment)
+		if err != nil {
+			switch {
+			case errors.Is(err, pkg.TransferToSelf):
+				res.WriteHeader(http.StatusBadRequest)
+			case errors.Is(err, pkg.UserNotExist):
+				res.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, pkg.InsufficientFunds):
+				res.WriteHeader(http.StatusPaymentRequired)
+			case errors.Is(err, pkg.TransferLimitExceeded):
+				res.WriteHeader(http.StatusUnprocessableEntity)
+			default:
+				log.Error("cannot transfer points: ", zap.Error(err))
+				res.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,38 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// курсор постраничных списков (заказы, выписка по баллам) - время и id последней записи страницы.
+// Время берется как есть из бд и обратно в запрос уходит с теми же часами
+func Encode(t time.Time, id int64) string {
+	raw := t.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func Decode(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	timePart, idPart, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	afterTime, err := time.Parse(time.RFC3339Nano, timePart)
+	if err != nil {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	afterID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	return afterTime, afterID, nil
+}
@@ -0,0 +1,27 @@
+package cursor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	want := time.Date(2023, 9, 1, 12, 30, 15, 123456000, time.UTC)
+	gotTime, gotID, err := Decode(Encode(want, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotTime.Equal(want) || gotID != 42 {
+		t.Errorf("Decode(Encode()) = %v, %d, want %v, 42", gotTime, gotID, want)
+	}
+}
+
+func TestDecodeBad(t *testing.T) {
+	for _, cursor := range []string{"!!!", "bm8tc2VwYXJhdG9y", "eHx5"} {
+		_, _, err := Decode(cursor)
+		if !errors.Is(err, ErrBadCursor) {
+			t.Errorf("Decode(%q) error = %v, want ErrBadCursor", cursor, err)
+		}
+	}
+}
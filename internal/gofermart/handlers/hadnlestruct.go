@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/auth"
 	"github.com/MlDenis/internal/gofermart/auth/cache"
 	"github.com/MlDenis/internal/gofermart/storage"
 )
@@ -9,11 +12,21 @@ import (
 type HandlerDB struct {
 	Storage storage.Interface
 	DataJWT *cache.DataJWT
+	Keys    *auth.KeySet
+	Lockout *auth.Lockout
+	//сколько хранить ответы на запросы с Idempotency-Key
+	IdempotencyWindow time.Duration
+	//формат ответов по умолчанию для клиентов без заголовка X-API-Version
+	APIVersion string
 }
 
-func HandlerNew(s storage.Interface, DataJWT *cache.DataJWT) *HandlerDB {
+func HandlerNew(s storage.Interface, DataJWT *cache.DataJWT, keys *auth.KeySet, lockout *auth.Lockout, idempotencyWindow time.Duration, apiVersion string) *HandlerDB {
 	return &HandlerDB{
-		Storage: s,
-		DataJWT: DataJWT,
+		Storage:           s,
+		DataJWT:           DataJWT,
+		Keys:              keys,
+		Lockout:           lockout,
+		IdempotencyWindow: idempotencyWindow,
+		APIVersion:        apiVersion,
 	}
 }
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/storage"
+	"go.uber.org/zap"
+)
+
+// максимальная длина ключа идемпотентности
+const maxIdempotencyKeyLen = 255
+
+// незавершенный запрос старше этого считаем брошенным (процесс упал или перезапущен),
+// повтор с тем же телом выполняется заново
+const idempotencyLease = time.Minute
+
+// сколько ждем запись результата в бд, запись не зависит от отмены запроса клиентом
+const idempotencyWriteTimeout = 5 * time.Second
+
+// запоминаем ответ на запрос с заголовком Idempotency-Key и отдаем его же на повтор.
+// Повтор с тем же ключом, но другим телом отклоняется с 422, пока первый запрос
+// обрабатывается - 409. Ставится после Authentication, ключи у каждого пользователя свои
+func Idempotency(store storage.InterfaceIdempotency, window time.Duration, log *zap.Logger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			key := req.Header.Get(models.HeaderIdempotencyKey)
+			if key == "" {
+				h.ServeHTTP(res, req)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				log.Error("idempotency key is too long")
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				log.Error("cannot read request body", zap.Error(err))
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := req.Context()
+			login := auth.LoginFromContext(ctx)
+			fingerprint := requestFingerprint(req, body)
+			now := time.Now()
+			record, created, err := store.BeginIdempotentRequest(ctx, login, key, fingerprint, now.Add(-window), now.Add(-idempotencyLease))
+			if err != nil {
+				log.Error("cannot check idempotency key: ", zap.Error(err))
+				res.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !created {
+				switch {
+				case record.Fingerprint != fingerprint:
+					log.Error("idempotency key reused with a different request", zap.String("login", login))
+					res.WriteHeader(http.StatusUnprocessableEntity)
+				case !record.Completed:
+					log.Error("request with this idempotency key is still in progress", zap.String("login", login))
+					res.WriteHeader(http.StatusConflict)
+				default:
+					if record.ContentType != "" {
+						res.Header().Set("Content-Type", record.ContentType)
+					}
+					res.Header().Set(models.HeaderIdempotentReplay, "true")
+					res.WriteHeader(record.StatusCode)
+					res.Write(record.Body)
+				}
+				return
+			}
+
+			//хэндлер может завершить операцию, даже если клиент отключился, поэтому результат
+			//записываем в отдельном контексте, иначе ключ навсегда останется "в обработке"
+			saveCtx, cancel := context.WithTimeout(context.Background(), idempotencyWriteTimeout)
+			defer cancel()
+			defer func() {
+				if p := recover(); p != nil {
+					//при панике освобождаем ключ, чтобы запрос можно было повторить
+					if err := store.DeleteIdempotencyKey(saveCtx, login, key); err != nil {
+						log.Error("cannot release idempotency key: ", zap.Error(err))
+					}
+					panic(p)
+				}
+			}()
+
+			rec := &responseRecorder{ResponseWriter: res, status: http.StatusOK}
+			h.ServeHTTP(rec, req)
+			//ошибку сервера не запоминаем, чтобы клиент мог повторить запрос
+			if rec.status >= http.StatusInternalServerError {
+				err = store.DeleteIdempotencyKey(saveCtx, login, key)
+			} else {
+				err = store.CompleteIdempotentRequest(saveCtx, login, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+			}
+			if err != nil {
+				log.Error("cannot save idempotent response: ", zap.Error(err))
+			}
+		})
+	}
+}
+
+// отпечаток запроса: метод, путь и тело
+func requestFingerprint(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// пишет ответ клиенту и сохраняет копию кода и тела
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
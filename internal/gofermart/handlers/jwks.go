@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// открытые ключи подписи токенов для внутренних сервисов
+func (m *HandlerDB) JWKS(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		response, err := json.Marshal(m.Keys.JWKS())
+		if err != nil {
+			log.Error("cannot marshal to json: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write(response)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/auth/cache"
+	"github.com/MlDenis/internal/gofermart/models"
+	"go.uber.org/zap"
+)
+
+// проверяем токен пользователя и кладем его логин в контекст запроса
+func Authentication(DataJWT *cache.DataJWT, keys *auth.KeySet, log *zap.Logger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			token := tokenFromRequest(req)
+			if token == "" {
+				log.Error("user not authenticated: no token")
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			claims, err := keys.ParseJwtToken(token)
+			if err != nil {
+				log.Error("user not authenticated: ", zap.Error(err))
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			revoked, err := DataJWT.IsRevoked(req.Context(), claims.ID)
+			if err != nil {
+				log.Error("cannot check token revocation: ", zap.Error(err))
+				res.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				log.Error("user not authenticated: token revoked")
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			//Проверяем, что сессия с этим токеном еще существует
+			userData := &models.UserData{Token: token}
+			err = DataJWT.GetToken(req.Context(), userData)
+			if err != nil || userData.Login != claims.Username {
+				log.Error("user not authenticated: ", zap.Error(err))
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(res, req.WithContext(auth.WithClaims(req.Context(), claims)))
+		})
+	}
+}
+
+// пропускаем только пользователей с одной из ролей, ставится после Authentication
+func RequireRole(log *zap.Logger, roles ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			role := auth.RoleFromContext(req.Context())
+			for _, allowed := range roles {
+				if role == allowed {
+					h.ServeHTTP(res, req)
+					return
+				}
+			}
+			log.Error("access denied", zap.String("login", auth.LoginFromContext(req.Context())), zap.String("role", role))
+			res.WriteHeader(http.StatusForbidden)
+		})
+	}
+}
+
+// токен берем из заголовка Authorization (с префиксом Bearer или без него) или из cookie
+func tokenFromRequest(req *http.Request) string {
+	header := strings.TrimSpace(req.Header.Get(models.HeaderHTTP))
+	if header != "" {
+		if len(header) > len(models.BearerPrefix) && strings.EqualFold(header[:len(models.BearerPrefix)], models.BearerPrefix) {
+			return strings.TrimSpace(header[len(models.BearerPrefix):])
+		}
+		return header
+	}
+	cookie, err := req.Cookie(models.CookieToken)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,69 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/luna"
+	"github.com/MlDenis/pkg"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// заказ пользователя с историей смены статусов, формат спецификации
+func (m *HandlerOrderseDB) GetUserOrderDetails(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		orderID := chi.URLParam(req, "number")
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		orderID = luna.Normalize(orderID)
+
+		order, err := m.StorageOrders.GetUserOrder(ctx, login, orderID)
+		if err != nil {
+			if errors.Is(err, pkg.OrderNotFound) {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			log.Error("cannot get user's order: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		history, err := m.StorageOrders.GetOrderStatusHistory(ctx, orderID)
+		if err != nil {
+			log.Error("cannot get order status history: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for i := range history {
+			history[i].ChangedAt = models.ServerTime(history[i].ChangedAt)
+		}
+
+		details := models.OrderDetails{
+			OrderListItem: orderListItem(*order),
+			History:       history,
+		}
+		orderJSON, err := json.Marshal(details)
+		if err != nil {
+			log.Error("cannot make json order: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write(orderJSON)
+	}
+}
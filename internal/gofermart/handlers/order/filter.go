@@ -0,0 +1,82 @@
+package order
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/handlers/cursor"
+	"github.com/MlDenis/internal/gofermart/models"
+)
+
+// максимальный размер страницы списка заказов
+const ordersMaxLimit = 500
+
+// параметры списка заказов: status (через запятую), from и to в RFC3339,
+// sort=asc|desc по времени загрузки, limit и cursor из заголовка X-Next-Cursor.
+// Порядок по умолчанию зависит от формата: спецификация требует сортировку
+// "от самых старых к самым новым", поэтому в формате спецификации (v2) - asc,
+// в прежнем формате (v1) - desc, новые заказы первыми.
+// Без limit отдаем все заказы, формат ответа не меняется
+func ordersFilterFromQuery(req *http.Request, apiVersion string) (models.OrdersFilter, error) {
+	query := req.URL.Query()
+	filter := models.OrdersFilter{}
+	if status := query.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s = strings.ToUpper(strings.TrimSpace(s))
+			switch s {
+			case models.NewOrder, models.ProcessingOrder, models.InvalidOrder, models.ProcessedOrder:
+				filter.Statuses = append(filter.Statuses, s)
+			default:
+				return filter, errors.New("bad status " + s)
+			}
+		}
+	}
+	//время загрузки хранится без зоны по часам сервера, поэтому границы периода переводим в локальное время
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, err
+		}
+		t = t.Local()
+		filter.From = &t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, err
+		}
+		t = t.Local()
+		filter.To = &t
+	}
+	switch query.Get("sort") {
+	case "":
+		filter.Desc = apiVersion != models.APIVersionSpec
+	case "desc":
+		filter.Desc = true
+	case "asc":
+	default:
+		return filter, errors.New("bad sort")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return filter, errors.New("bad limit")
+		}
+		if n > ordersMaxLimit {
+			n = ordersMaxLimit
+		}
+		filter.Limit = n
+	}
+	if after := query.Get("cursor"); after != "" {
+		afterTime, afterID, err := cursor.Decode(after)
+		if err != nil {
+			return filter, err
+		}
+		filter.AfterTime = &afterTime
+		filter.AfterID = afterID
+	}
+	return filter, nil
+}
@@ -0,0 +1,33 @@
+package order
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MlDenis/internal/gofermart/models"
+)
+
+// без sort формат спецификации идет от старых к новым, прежний формат - от новых к старым
+func TestOrdersFilterSort(t *testing.T) {
+	tests := []struct {
+		query      string
+		apiVersion string
+		wantDesc   bool
+	}{
+		{query: "", apiVersion: models.APIVersionSpec, wantDesc: false},
+		{query: "", apiVersion: models.APIVersionLegacy, wantDesc: true},
+		{query: "?sort=desc", apiVersion: models.APIVersionSpec, wantDesc: true},
+		{query: "?sort=asc", apiVersion: models.APIVersionLegacy, wantDesc: false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+tt.query, nil)
+		filter, err := ordersFilterFromQuery(req, tt.apiVersion)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.apiVersion, tt.query, err)
+		}
+		if filter.Desc != tt.wantDesc {
+			t.Errorf("%s %q: Desc = %v, want %v", tt.apiVersion, tt.query, filter.Desc, tt.wantDesc)
+		}
+	}
+}
@@ -6,12 +6,13 @@ import (
 	"errors"
 	"io"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/handlers/cursor"
 	"github.com/MlDenis/internal/gofermart/models"
 	"github.com/MlDenis/internal/luna"
 	"github.com/MlDenis/pkg"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 )
 
@@ -28,30 +29,24 @@ func (m *HandlerOrderseDB) LoadOrderNumber(ctx context.Context, log *zap.Logger)
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		//Создадим структуру пользователя, чтобы записать токен и логин в него
-		jsonUsers := &models.UserData{}
+		login := auth.LoginFromContext(req.Context())
 		//Создадим структуру заказов, чтобы записать их в бд
 		jsonOrders := &models.Orders{}
-		jsonUsers.Token = req.Header.Get(models.HeaderHTTP)
-		err := m.DataJWT.GetToken(jsonUsers)
-		if err != nil {
-			log.Error("user not authenticated: ", zap.Error(err))
-			res.WriteHeader(http.StatusUnauthorized)
-			return
-		}
 
 		number, err := io.ReadAll(req.Body)
 		if err != nil {
 			log.Error("error in read request body: ", zap.Error(err))
 			res.WriteHeader(http.StatusBadRequest)
+			return
 		}
-		//проверям заказ через алгоритм луна
-		orderID, err := strconv.ParseInt(string(number), 10, 64)
-		if err != nil {
-			log.Error("wrong order number:", zap.Error(err))
+		//номер заказа - строка цифр любой длины, проверям ее через алгоритм луна
+		orderID := string(number)
+		if !luna.Numeric(orderID) {
+			log.Error("wrong order number", zap.String("number", orderID))
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		orderID = luna.Normalize(orderID)
 		validNumber := luna.Valid(orderID)
 		if !validNumber {
 			log.Error("invalid order number")
@@ -59,22 +54,21 @@ func (m *HandlerOrderseDB) LoadOrderNumber(ctx context.Context, log *zap.Logger)
 			return
 		}
 		jsonOrders.OrderNumber = orderID
-		jsonOrders.UserLogin = jsonUsers.Login
+		jsonOrders.UserLogin = login
 		err = m.StorageOrders.LoadOrderInDB(ctx, jsonOrders)
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgErr.Code == pkg.UniqueViolationCode {
-					log.Error("the order number has already been uploaded by the user")
-					res.WriteHeader(http.StatusOK)
-					return
-				}
-				log.Error("error in add orders in db: ", zap.Error(err))
-				res.WriteHeader(http.StatusBadRequest)
+			if errors.Is(err, pkg.OrderAlreadyUploaded) {
+				log.Error("the order number has already been uploaded by the user")
+				res.WriteHeader(http.StatusOK)
+				return
+			}
+			if errors.Is(err, pkg.OrderUploadedByAnotherUser) {
+				log.Error("the order number has already been uploaded by another user")
+				res.WriteHeader(http.StatusConflict)
 				return
 			}
 			log.Error("error in add orders in db: ", zap.Error(err))
-			res.WriteHeader(http.StatusBadRequest)
+			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		log.Error("new order number accepted for processing")
@@ -84,6 +78,8 @@ func (m *HandlerOrderseDB) LoadOrderNumber(ctx context.Context, log *zap.Logger)
 
 }
 
+// список заказов пользователя. Формат ответа выбирается заголовком X-API-Version,
+// без заголовка - версией по умолчанию из настроек сервера
 func (m *HandlerOrderseDB) GetUserOrder(ctx context.Context, log *zap.Logger) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		if req.Method != http.MethodGet {
@@ -91,18 +87,33 @@ func (m *HandlerOrderseDB) GetUserOrder(ctx context.Context, log *zap.Logger) ht
 			res.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		//Проверяем токен
-		userData := &models.UserData{}
-		userData.Token = req.Header.Get(models.HeaderHTTP)
-
-		err := m.DataJWT.GetToken(userData)
+		apiVersion := m.APIVersion
+		if version := req.Header.Get(models.HeaderAPIVersion); version != "" {
+			apiVersion = version
+		}
+		if apiVersion != models.APIVersionLegacy && apiVersion != models.APIVersionSpec {
+			log.Error("unknown api version", zap.String("version", apiVersion))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		filter, err := ordersFilterFromQuery(req, apiVersion)
 		if err != nil {
-			log.Error("user not authenticated: ", zap.Error(err))
-			res.WriteHeader(http.StatusUnauthorized)
+			log.Error("bad orders query: ", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		//в формате спецификации списания баллов в списке заказов не показываем
+		if apiVersion == models.APIVersionSpec && len(filter.Statuses) == 0 {
+			filter.Statuses = []string{models.NewOrder, models.ProcessingOrder, models.InvalidOrder, models.ProcessedOrder}
+		}
+		limit := filter.Limit
+		//берем на одну запись больше, чтобы понять, есть ли следующая страница
+		if limit > 0 {
+			filter.Limit++
+		}
 
-		orders, err := m.StorageOrders.GetUserOrders(ctx, userData.Login)
+		orders, err := m.StorageOrders.GetUserOrders(ctx, login, filter)
 		if err != nil {
 			if errors.Is(err, pkg.NoOrders) {
 				res.WriteHeader(http.StatusNoContent)
@@ -113,7 +124,18 @@ func (m *HandlerOrderseDB) GetUserOrder(ctx context.Context, log *zap.Logger) ht
 			return
 		}
 
-		ordersJson, err := json.Marshal(orders)
+		//ответ остается массивом заказов, курсор следующей страницы отдаем в заголовке
+		if limit > 0 && len(orders) > limit {
+			orders = orders[:limit]
+			res.Header().Set(models.HeaderNextCursor, cursor.Encode(orders[limit-1].OrderDate, orders[limit-1].ID))
+		}
+
+		var ordersJson []byte
+		if apiVersion == models.APIVersionSpec {
+			ordersJson, err = json.Marshal(orderListItems(orders))
+		} else {
+			ordersJson, err = json.Marshal(legacyOrders(orders))
+		}
 		if err != nil {
 			log.Error("cannot make json orders: ", zap.Error(err))
 			res.WriteHeader(http.StatusInternalServerError)
@@ -121,6 +143,7 @@ func (m *HandlerOrderseDB) GetUserOrder(ctx context.Context, log *zap.Logger) ht
 		}
 
 		res.Header().Set("Content-type", "application/json")
+		res.Header().Set(models.HeaderAPIVersion, apiVersion)
 
 		_, err = res.Write(ordersJson)
 		if err != nil {
@@ -129,3 +152,34 @@ func (m *HandlerOrderseDB) GetUserOrder(ctx context.Context, log *zap.Logger) ht
 		}
 	}
 }
+
+// прежний формат списка: order_number, order_date, status_order
+func legacyOrders(orders []models.Orders) []models.OrdersOnly {
+	list := make([]models.OrdersOnly, 0, len(orders))
+	for _, order := range orders {
+		list = append(list, order.OrdersOnly)
+	}
+	return list
+}
+
+// формат спецификации: number, status, accrual, uploaded_at в RFC3339
+func orderListItems(orders []models.Orders) []models.OrderListItem {
+	list := make([]models.OrderListItem, 0, len(orders))
+	for _, order := range orders {
+		list = append(list, orderListItem(order))
+	}
+	return list
+}
+
+func orderListItem(order models.Orders) models.OrderListItem {
+	item := models.OrderListItem{
+		Number:     order.OrderNumber,
+		Status:     order.StatusOrder,
+		UploadedAt: models.ServerTime(order.OrderDate).Format(time.RFC3339),
+	}
+	if order.StatusOrder == models.ProcessedOrder {
+		accrual := order.Accrual
+		item.Accrual = &accrual
+	}
+	return item
+}
@@ -0,0 +1,102 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/storage"
+	"github.com/MlDenis/pkg"
+	"go.uber.org/zap"
+)
+
+// хранилище, которое на загрузку заказа возвращает заданную ошибку
+type loadOrderStorage struct {
+	storage.InterfaceOrders
+	err error
+}
+
+func (s *loadOrderStorage) LoadOrderInDB(ctx context.Context, orders *models.Orders) error {
+	return s.err
+}
+
+func TestLoadOrderNumberStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "new order", err: nil, want: http.StatusAccepted},
+		{name: "uploaded by this user", err: pkg.OrderAlreadyUploaded, want: http.StatusOK},
+		{name: "uploaded by another user", err: pkg.OrderUploadedByAnotherUser, want: http.StatusConflict},
+		{name: "storage error", err: errors.New("db is down"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := HandlerOrders(&loadOrderStorage{err: tt.err}, models.APIVersionLegacy)
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("12345678903"))
+			req.Header.Set("Content-Type", "text/plain")
+			req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "user"}))
+			res := httptest.NewRecorder()
+
+			m.LoadOrderNumber(context.Background(), zap.NewNop())(res, req)
+
+			if res.Code != tt.want {
+				t.Errorf("status = %d, want %d", res.Code, tt.want)
+			}
+		})
+	}
+}
+
+// хранилище, которое запоминает фильтр списка заказов
+type listOrdersStorage struct {
+	storage.InterfaceOrders
+	filter models.OrdersFilter
+}
+
+func (s *listOrdersStorage) GetUserOrders(ctx context.Context, userlogin string, filter models.OrdersFilter) ([]models.Orders, error) {
+	s.filter = filter
+	return []models.Orders{}, nil
+}
+
+// порядок списка без sort зависит от версии, заданной флагом или заголовком:
+// v2 - от старых к новым по спецификации, v1 - от новых к старым, как раньше
+func TestGetUserOrderDefaultSort(t *testing.T) {
+	tests := []struct {
+		name       string
+		defVersion string
+		header     string
+		wantDesc   bool
+	}{
+		{name: "v1 by default", defVersion: models.APIVersionLegacy, wantDesc: true},
+		{name: "v2 by default", defVersion: models.APIVersionSpec, wantDesc: false},
+		{name: "v1 by header", defVersion: models.APIVersionSpec, header: models.APIVersionLegacy, wantDesc: true},
+		{name: "v2 by header", defVersion: models.APIVersionLegacy, header: models.APIVersionSpec, wantDesc: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &listOrdersStorage{}
+			m := HandlerOrders(s, tt.defVersion)
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			if tt.header != "" {
+				req.Header.Set(models.HeaderAPIVersion, tt.header)
+			}
+			req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "user"}))
+			res := httptest.NewRecorder()
+
+			m.GetUserOrder(context.Background(), zap.NewNop())(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", res.Code, http.StatusOK)
+			}
+			if s.filter.Desc != tt.wantDesc {
+				t.Errorf("Desc = %v, want %v", s.filter.Desc, tt.wantDesc)
+			}
+		})
+	}
+}
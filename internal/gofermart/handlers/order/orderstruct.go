@@ -1,19 +1,19 @@
 package order
 
 import (
-	"github.com/MlDenis/internal/gofermart/auth/cache"
 	"github.com/MlDenis/internal/gofermart/storage"
 )
 
 // структура для наших хэндлеров, далее надо будет добавить возмонжо логер и тд
 type HandlerOrderseDB struct {
 	StorageOrders storage.InterfaceOrders
-	DataJWT       *cache.DataJWT
+	//формат ответов по умолчанию: models.APIVersionLegacy или models.APIVersionSpec
+	APIVersion string
 }
 
-func HandlerOrders(orders storage.InterfaceOrders, DataJWT *cache.DataJWT) *HandlerOrderseDB {
+func HandlerOrders(orders storage.InterfaceOrders, apiVersion string) *HandlerOrderseDB {
 	return &HandlerOrderseDB{
 		StorageOrders: orders,
-		DataJWT:       DataJWT,
+		APIVersion:    apiVersion,
 	}
 }
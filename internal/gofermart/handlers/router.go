@@ -3,27 +3,58 @@ package handlers
 import (
 	"context"
 
+	"github.com/MlDenis/internal/gofermart/handlers/admin"
 	"github.com/MlDenis/internal/gofermart/handlers/balance"
 	"github.com/MlDenis/internal/gofermart/handlers/order"
 	"github.com/MlDenis/internal/gofermart/handlers/users"
+	"github.com/MlDenis/internal/gofermart/models"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
 func Router(ctx context.Context, log *zap.Logger, newHandStruct *HandlerDB) chi.Router {
-	Balance := balance.HandlerBalance(newHandStruct.Storage, newHandStruct.DataJWT)
-	Users := users.HandlerUsers(newHandStruct.Storage, newHandStruct.DataJWT)
-	Orders := order.HandlerOrders(newHandStruct.Storage, newHandStruct.DataJWT)
+	Balance := balance.HandlerBalance(newHandStruct.Storage)
+	Users := users.HandlerUsers(newHandStruct.Storage, newHandStruct.DataJWT, newHandStruct.Keys, newHandStruct.Lockout)
+	Orders := order.HandlerOrders(newHandStruct.Storage, newHandStruct.APIVersion)
+	Admin := admin.HandlerAdmin(newHandStruct.Storage, newHandStruct.Storage, newHandStruct.DataJWT)
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
+	r.Get("/.well-known/jwks.json", newHandStruct.JWKS(ctx, log))
 	r.Post("/api/user/register", Users.RegisterNewUser(ctx, log))
 	r.Post("/api/user/login", Users.AuthorizationUser(ctx, log))
-	r.Post("/api/user/orders", Orders.LoadOrderNumber(ctx, log))
-	r.Get("/api/user/orders", Orders.GetUserOrder(ctx, log))
-	r.Get("/api/user/balance", Balance.GetBalance(ctx, log))
-	r.Post("/api/user/balance/withdraw", Balance.WithdrawBalance(ctx, log))
-	r.Get("/api/user/withdrawals", Balance.GetWithdrawals(ctx, log))
+	r.Post("/api/user/login/2fa", Users.LoginTwoFactor(ctx, log))
+	r.Post("/api/user/token/refresh", Users.RefreshToken(ctx, log))
+	//хэндлеры, доступные только аутентифицированным пользователям
+	r.Group(func(r chi.Router) {
+		r.Use(Authentication(newHandStruct.DataJWT, newHandStruct.Keys, log))
+		r.Post("/api/user/logout", Users.Logout(ctx, log))
+		r.Post("/api/user/logout-all", Users.LogoutAll(ctx, log))
+		r.Post("/api/user/password", Users.ChangePassword(ctx, log))
+		r.Delete("/api/user", Users.DeleteUser(ctx, log))
+		r.Post("/api/user/2fa/enroll", Users.EnrollTOTP(ctx, log))
+		r.Post("/api/user/2fa/confirm", Users.ConfirmTOTP(ctx, log))
+		r.With(Idempotency(newHandStruct.Storage, newHandStruct.IdempotencyWindow, log)).Post("/api/user/orders", Orders.LoadOrderNumber(ctx, log))
+		r.Get("/api/user/orders", Orders.GetUserOrder(ctx, log))
+		r.Get("/api/user/orders/{number}", Orders.GetUserOrderDetails(ctx, log))
+		r.Get("/api/user/balance", Balance.GetBalance(ctx, log))
+		r.Get("/api/user/balance/history", Balance.GetBalanceHistory(ctx, log))
+		r.With(Idempotency(newHandStruct.Storage, newHandStruct.IdempotencyWindow, log)).Post("/api/user/balance/withdraw", Balance.WithdrawBalance(ctx, log))
+		r.With(Idempotency(newHandStruct.Storage, newHandStruct.IdempotencyWindow, log)).Post("/api/user/balance/transfer", Balance.TransferBalance(ctx, log))
+		r.With(Idempotency(newHandStruct.Storage, newHandStruct.IdempotencyWindow, log)).Post("/api/user/balance/holds", Balance.CreateHold(ctx, log))
+		r.Post("/api/user/balance/holds/{id}/capture", Balance.CaptureHold(ctx, log))
+		r.Post("/api/user/balance/holds/{id}/release", Balance.ReleaseHold(ctx, log))
+		r.Get("/api/user/withdrawals", Balance.GetWithdrawals(ctx, log))
+	})
+	//служебные хэндлеры: поддержка может смотреть, администратор - менять
+	r.Group(func(r chi.Router) {
+		r.Use(Authentication(newHandStruct.DataJWT, newHandStruct.Keys, log))
+		r.With(RequireRole(log, models.RoleSupport, models.RoleAdmin)).Get("/api/admin/users/{login}/balance", Admin.GetUserBalance(ctx, log))
+		r.With(RequireRole(log, models.RoleAdmin)).Post("/api/admin/users/{login}/balance/adjust", Admin.AdjustUserBalance(ctx, log))
+		r.With(RequireRole(log, models.RoleAdmin)).Put("/api/admin/users/{login}/role", Admin.SetUserRole(ctx, log))
+		r.With(RequireRole(log, models.RoleAdmin)).Post("/api/admin/withdrawals/{number}/reverse", Admin.ReverseWithdrawal(ctx, log))
+		r.With(RequireRole(log, models.RoleAdmin)).Get("/api/admin/ledger/reconcile", Admin.ReconcileLedger(ctx, log))
+	})
 	return r
 }
@@ -0,0 +1,150 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"go.uber.org/zap"
+)
+
+// смена пароля, все сессии кроме текущей завершаются
+func (m *HandlerUserDB) ChangePassword(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			log.Error("user not authenticated")
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		changeReq := &models.ChangePasswordRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(changeReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if changeReq.NewPassword == "" {
+			log.Error("empty new password")
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !m.checkPassword(ctx, log, res, req, claims.Username, changeReq.OldPassword) {
+			return
+		}
+		hash, err := auth.HashPassword(changeReq.NewPassword)
+		if err != nil {
+			log.Error("cannot hash password", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = m.StorageUsers.UpdatePasswordHash(ctx, claims.Username, hash)
+		if err != nil {
+			log.Error("cannot update password hash", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = m.DataJWT.RevokeOthers(ctx, claims.Username, claims.SessionID)
+		if err != nil {
+			log.Error("tokens not revoked", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// удаление аккаунта, политика хранения заказов описана в storage.DeleteUser
+func (m *HandlerUserDB) DeleteUser(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodDelete {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		deleteReq := &models.DeleteUserRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(deleteReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !m.checkPassword(ctx, log, res, req, login, deleteReq.Password) {
+			return
+		}
+		//токены отзываем до удаления: при ошибке аккаунт остается, и запрос можно повторить.
+		//хранилище в бд дополнительно отзывает их в транзакции удаления
+		err := m.DataJWT.RevokeAll(ctx, login)
+		if err != nil {
+			log.Error("tokens not revoked", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = m.StorageUsers.DeleteUser(ctx, login)
+		if err != nil {
+			log.Error("cannot delete user", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		clearTokenCookie(res)
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// сверяем пароль пользователя с хэшем из бд. Неудачи учитываются так же, как при входе
+// (см. AuthorizationUser), иначе с украденным токеном пароль можно подбирать без ограничений.
+// При отказе ответ клиенту уже записан
+func (m *HandlerUserDB) checkPassword(ctx context.Context, log *zap.Logger, res http.ResponseWriter, req *http.Request, login, password string) bool {
+	ip := clientIP(req)
+	retryAfter, err := m.Lockout.Check(ctx, login, ip)
+	if err != nil {
+		log.Error("cannot check login attempts", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if retryAfter > 0 {
+		log.Error("too many failed password attempts", zap.String("login", login), zap.String("ip", ip))
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		res.WriteHeader(http.StatusTooManyRequests)
+		return false
+	}
+	userData := &models.UserData{Login: login}
+	err = m.StorageUsers.GetUser(ctx, userData)
+	if err != nil {
+		log.Error("cannot get user", zap.Error(err))
+		res.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	ok, _, err := auth.VerifyPassword(password, userData.PasswordHash)
+	if err != nil || !ok {
+		log.Error("wrong password", zap.Error(err))
+		m.loginFailed(ctx, log, login, ip)
+		res.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	err = m.Lockout.Success(ctx, login)
+	if err != nil {
+		log.Error("cannot reset login attempts", zap.Error(err))
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/storage"
+	"go.uber.org/zap"
+)
+
+// хранилище пользователей с одним пользователем и заданным хэшем пароля
+type passwordStorage struct {
+	storage.InterfaceUser
+	hash    string
+	updated bool
+}
+
+func (s *passwordStorage) GetUser(ctx context.Context, userData *models.UserData) error {
+	userData.PasswordHash = s.hash
+	return nil
+}
+
+func (s *passwordStorage) UpdatePasswordHash(ctx context.Context, userlogin string, hash string) error {
+	s.updated = true
+	return nil
+}
+
+// счетчики неудачных попыток в памяти
+type memoryAttempts struct {
+	failures map[string]int
+	locks    map[string]time.Time
+}
+
+func (s *memoryAttempts) GetLoginLock(ctx context.Context, key string) (time.Time, error) {
+	return s.locks[key], nil
+}
+
+func (s *memoryAttempts) RegisterLoginFailure(ctx context.Context, key string, resetBefore time.Time) (int, error) {
+	s.failures[key]++
+	return s.failures[key], nil
+}
+
+func (s *memoryAttempts) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.locks[key] = until
+	return nil
+}
+
+func (s *memoryAttempts) ResetLoginFailures(ctx context.Context, key string) error {
+	delete(s.failures, key)
+	return nil
+}
+
+func (s *memoryAttempts) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+// неверный старый пароль учитывается защитой от перебора: после исчерпания попыток
+// даже верный пароль получает 429, и пароль не меняется
+func TestChangePasswordLockout(t *testing.T) {
+	hash, err := auth.HashPassword("right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &passwordStorage{hash: hash}
+	attempts := &memoryAttempts{failures: map[string]int{}, locks: map[string]time.Time{}}
+	const maxAttempts = 3
+	m := HandlerUsers(users, nil, nil, auth.NewLockout(attempts, maxAttempts, 100, time.Minute, time.Hour))
+
+	changePassword := func(oldPassword string) int {
+		body := `{"old_password":"` + oldPassword + `","new_password":"new"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/user/password", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "user"}))
+		res := httptest.NewRecorder()
+		m.ChangePassword(context.Background(), zap.NewNop())(res, req)
+		return res.Code
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		if code := changePassword("wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, code, http.StatusUnauthorized)
+		}
+	}
+	if code := changePassword("right"); code != http.StatusTooManyRequests {
+		t.Errorf("status after lockout = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if users.updated {
+		t.Error("password changed while the login is locked")
+	}
+}
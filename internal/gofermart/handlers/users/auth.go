@@ -3,10 +3,16 @@ package users
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/MlDenis/internal/gofermart/auth"
 	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 )
 
@@ -33,13 +39,26 @@ func (m *HandlerUserDB) RegisterNewUser(ctx context.Context, log *zap.Logger) ht
 			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		jsonUsers.PasswordHash = auth.HashPassword(jsonUsers.Password)
-		err := m.StorageUsers.RegisterUser(ctx, jsonUsers)
+		//логины deleted:<id> принадлежат удаленным аккаунтам
+		if models.IsReservedLogin(jsonUsers.Login) {
+			log.Error("reserved login", zap.String("login", jsonUsers.Login))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var err error
+		jsonUsers.PasswordHash, err = auth.HashPassword(jsonUsers.Password)
 		if err != nil {
-			err := m.StorageUsers.GetUser(ctx, &jsonUsers)
-			if err == nil {
+			log.Error("cannot hash password", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = m.StorageUsers.RegisterUser(ctx, jsonUsers)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == pkg.UniqueViolationCode {
 				log.Error("login busy")
 				res.WriteHeader(http.StatusConflict)
+				return
 			}
 			log.Error("failed to register user", zap.Error(err))
 			res.WriteHeader(http.StatusBadRequest)
@@ -52,15 +71,14 @@ func (m *HandlerUserDB) RegisterNewUser(ctx context.Context, log *zap.Logger) ht
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		//создаем токен для пользователя, который будет храниться в RAM
-		jsonUsers.Token, err = auth.CreateJwtToken(jsonUsers.Login)
+		//создаем токены для пользователя, которые будут храниться в хранилище сессий
+		jsonUsers.Role = models.RoleUser
+		err = m.issueTokens(ctx, res, &jsonUsers, "")
 		if err != nil {
-			log.Error("token not created")
-			res.WriteHeader(http.StatusBadRequest)
+			log.Error("tokens not created", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		m.DataJWT.AddToken(&jsonUsers)
-		res.Header().Add(models.HeaderHTTP, jsonUsers.Token)
 		res.WriteHeader(http.StatusOK)
 
 	}
@@ -90,26 +108,167 @@ func (m *HandlerUserDB) AuthorizationUser(ctx context.Context, log *zap.Logger)
 			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		//хэшируем пароль
-		jsonUsers.PasswordHash = auth.HashPassword(jsonUsers.Password)
-		err := m.StorageUsers.GetUser(ctx, jsonUsers)
+		//Проверяем, не заблокирован ли вход для логина или адреса после неудачных попыток
+		ip := clientIP(req)
+		retryAfter, err := m.Lockout.Check(ctx, jsonUsers.Login, ip)
+		if err != nil {
+			log.Error("cannot check login attempts", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if retryAfter > 0 {
+			log.Error("too many failed login attempts", zap.String("login", jsonUsers.Login), zap.String("ip", ip))
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			res.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		err = m.StorageUsers.GetUser(ctx, jsonUsers)
 		if err != nil {
 			log.Error("failed to autorization", zap.Error(err))
+			auth.VerifyDummyPassword(jsonUsers.Password)
+			m.loginFailed(ctx, log, jsonUsers.Login, ip)
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		//сверяем пароль с хэшем из бд
+		ok, needsRehash, err := auth.VerifyPassword(jsonUsers.Password, jsonUsers.PasswordHash)
+		if err != nil || !ok {
+			log.Error("failed to autorization: wrong password", zap.Error(err))
+			m.loginFailed(ctx, log, jsonUsers.Login, ip)
 			res.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		//создаем токен для пользователя, который будет храниться в RAM
-		jsonUsers.Token, err = auth.CreateJwtToken(jsonUsers.Login)
+		err = m.Lockout.Success(ctx, jsonUsers.Login)
 		if err != nil {
-			log.Error("token not created")
-			res.WriteHeader(http.StatusBadRequest)
+			log.Error("cannot reset login attempts", zap.Error(err))
+		}
+		//старый хэш пересчитываем, пока у нас есть пароль в открытом виде
+		if needsRehash {
+			m.rehashPassword(ctx, log, jsonUsers)
+		}
+		//если подключена 2FA, вместо сессии выдаем токен для второго шага входа
+		totp, err := m.StorageUsers.GetTOTP(ctx, jsonUsers.Login)
+		if err != nil {
+			log.Error("cannot get 2fa settings", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if totp.Enabled {
+			preAuthToken, err := m.Keys.CreatePreAuthToken(jsonUsers.Login)
+			if err != nil {
+				log.Error("pre-auth token not created", zap.Error(err))
+				res.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			writeJSON(res, log, http.StatusAccepted, models.PreAuthResponse{
+				TwoFactorRequired: true,
+				PreAuthToken:      preAuthToken,
+			})
+			return
+		}
+		//При авторизации создаем новую сессию и добавляем токены в хранилище сессий
+		err = m.issueTokens(ctx, res, jsonUsers, "")
+		if err != nil {
+			log.Error("tokens not created", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		//При авторизации добавляем токен в RAM
-		m.DataJWT.AddToken(jsonUsers)
-		res.Header().Add(models.HeaderHTTP, jsonUsers.Token)
 		res.WriteHeader(http.StatusOK)
 
 	}
 
 }
+
+// учитываем неудачную попытку входа, ошибка учета не меняет ответ клиенту
+func (m *HandlerUserDB) loginFailed(ctx context.Context, log *zap.Logger, login, ip string) {
+	err := m.Lockout.Fail(ctx, login, ip)
+	if err != nil {
+		log.Error("cannot register failed login attempt", zap.Error(err))
+	}
+}
+
+// адрес клиента берем из соединения: заголовки X-Forwarded-For подделываются клиентом
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// пересчитываем хэш пароля по текущему алгоритму, ошибка не мешает входу
+func (m *HandlerUserDB) rehashPassword(ctx context.Context, log *zap.Logger, userData *models.UserData) {
+	hash, err := auth.HashPassword(userData.Password)
+	if err != nil {
+		log.Error("cannot rehash password", zap.Error(err))
+		return
+	}
+	err = m.StorageUsers.UpdatePasswordHash(ctx, userData.Login, hash)
+	if err != nil {
+		log.Error("cannot update password hash", zap.Error(err))
+	}
+}
+
+// выход из текущей сессии, токен попадает в список отозванных
+func (m *HandlerUserDB) Logout(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			log.Error("user not authenticated")
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		err := m.DataJWT.Revoke(ctx, claims.Username, claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			log.Error("token not revoked", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		//refresh-токены этой сессии тоже больше не действуют
+		err = m.DataJWT.RevokeRefreshFamily(ctx, claims.SessionID)
+		if err != nil {
+			log.Error("refresh tokens not revoked", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		clearTokenCookie(res)
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// выход со всех устройств, отзываем все токены пользователя
+func (m *HandlerUserDB) LogoutAll(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			log.Error("user not authenticated")
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		//текущий токен отзываем явно, даже если его сессия уже удалена
+		err := m.DataJWT.Revoke(ctx, claims.Username, claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			log.Error("token not revoked", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = m.DataJWT.RevokeAll(ctx, claims.Username)
+		if err != nil {
+			log.Error("tokens not revoked", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		clearTokenCookie(res)
+		res.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,44 @@
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/storage"
+	"go.uber.org/zap"
+)
+
+// хранилище, которое запоминает, регистрировался ли пользователь
+type registerStorage struct {
+	storage.InterfaceUser
+	registered bool
+}
+
+func (s *registerStorage) RegisterUser(ctx context.Context, userData models.UserData) error {
+	s.registered = true
+	return nil
+}
+
+// логин обезличенного удаленного аккаунта не выдается: иначе новый пользователь
+// получил бы его заказы, списания и счет в журнале
+func TestRegisterNewUserDeletedLogin(t *testing.T) {
+	users := &registerStorage{}
+	m := HandlerUsers(users, nil, nil, nil)
+	body := `{"login":"` + models.DeletedLogin(42) + `","password":"secret"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+
+	m.RegisterNewUser(context.Background(), zap.NewNop())(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", res.Code, http.StatusBadRequest)
+	}
+	if users.registered {
+		t.Error("user with an anonymized login was registered")
+	}
+}
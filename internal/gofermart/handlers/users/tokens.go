@@ -0,0 +1,129 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"go.uber.org/zap"
+)
+
+// выпускаем пару access/refresh токенов и отдаем их клиенту в заголовках,
+// пустой sessionID означает новую сессию (новое семейство refresh-токенов)
+func (m *HandlerUserDB) issueTokens(ctx context.Context, res http.ResponseWriter, userData *models.UserData, sessionID string) error {
+	var err error
+	if sessionID == "" {
+		sessionID, err = auth.NewTokenID()
+		if err != nil {
+			return err
+		}
+	}
+	userData.SessionID = sessionID
+	userData.Token, userData.TokenID, err = m.Keys.CreateJwtToken(userData.Login, userData.Role, sessionID)
+	if err != nil {
+		return err
+	}
+	err = m.DataJWT.AddToken(ctx, userData)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := m.DataJWT.IssueRefreshToken(ctx, userData.Login, sessionID)
+	if err != nil {
+		return err
+	}
+	setTokenCookie(res, userData.Token)
+	res.Header().Add(models.HeaderHTTP, userData.Token)
+	res.Header().Add(models.HeaderRefresh, refreshToken)
+	return nil
+}
+
+// обновляем пару токенов по refresh-токену, старый refresh-токен становится использованным
+func (m *HandlerUserDB) RefreshToken(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		//refresh-токен принимаем из заголовка или из тела запроса
+		refreshReq := &models.RefreshRequest{RefreshToken: req.Header.Get(models.HeaderRefresh)}
+		if refreshReq.RefreshToken == "" {
+			if req.Header.Get("Content-Type") != "application/json" {
+				log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			dec := json.NewDecoder(req.Body)
+			if err := dec.Decode(refreshReq); err != nil {
+				log.Error("cannot decode request JSON body", zap.Error(err))
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		if refreshReq.RefreshToken == "" {
+			log.Error("empty refresh token")
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		refresh, err := m.DataJWT.UseRefreshToken(ctx, refreshReq.RefreshToken)
+		if err != nil {
+			if errors.Is(err, pkg.RefreshTokenReused) {
+				log.Error("refresh token reuse detected, session revoked", zap.Error(err))
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if errors.Is(err, pkg.RefreshTokenInvalid) {
+				log.Error("invalid refresh token", zap.Error(err))
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			log.Error("cannot use refresh token", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		//роль берем из бд: она могла измениться, а пользователь - удалить аккаунт
+		userData := &models.UserData{Login: refresh.Login}
+		err = m.StorageUsers.GetUser(ctx, userData)
+		if err != nil {
+			log.Error("cannot get user", zap.Error(err))
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		err = m.issueTokens(ctx, res, userData, refresh.FamilyID)
+		if err != nil {
+			log.Error("tokens not created", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// дублируем токен в cookie, чтобы браузерным клиентам не нужно было хранить его самим
+func setTokenCookie(res http.ResponseWriter, token string) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     models.CookieToken,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(auth.TOKEN_EXP),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// удаляем cookie с токеном при выходе
+func clearTokenCookie(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     models.CookieToken,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,225 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/auth"
+	"github.com/MlDenis/internal/gofermart/models"
+	"go.uber.org/zap"
+)
+
+// сколько кодов восстановления выдаем при подключении 2FA
+const recoveryCodesCount = 10
+
+// начинаем подключение 2FA: создаем секрет и отдаем ссылку otpauth://
+func (m *HandlerUserDB) EnrollTOTP(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		totp, err := m.StorageUsers.GetTOTP(ctx, login)
+		if err != nil {
+			log.Error("cannot get 2fa settings", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if totp.Enabled {
+			log.Error("2fa already enabled")
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			log.Error("cannot create totp secret", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = m.StorageUsers.SetTOTPSecret(ctx, login, secret)
+		if err != nil {
+			log.Error("cannot save totp secret", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, log, http.StatusOK, models.TOTPEnrollResponse{
+			Secret:     secret,
+			OtpauthURI: auth.TOTPURI(login, secret),
+		})
+	}
+}
+
+// подтверждаем подключение 2FA первым кодом из приложения и выдаем коды восстановления
+func (m *HandlerUserDB) ConfirmTOTP(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login := auth.LoginFromContext(req.Context())
+		confirmReq := &models.TOTPConfirmRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(confirmReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		totp, err := m.StorageUsers.GetTOTP(ctx, login)
+		if err != nil {
+			log.Error("cannot get 2fa settings", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if totp.Enabled {
+			log.Error("2fa already enabled")
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+		if totp.Secret == "" {
+			log.Error("2fa enrollment not started")
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		step, ok := auth.ValidateTOTP(totp.Secret, confirmReq.Code, time.Now())
+		if !ok {
+			log.Error("wrong totp code")
+			res.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		codes, err := auth.NewRecoveryCodes(recoveryCodesCount)
+		if err != nil {
+			log.Error("cannot create recovery codes", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		hashes := make([]string, 0, len(codes))
+		for _, code := range codes {
+			hashes = append(hashes, auth.HashRecoveryCode(code))
+		}
+		err = m.StorageUsers.EnableTOTP(ctx, login, step, hashes)
+		if err != nil {
+			log.Error("cannot enable 2fa", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, log, http.StatusOK, models.TOTPConfirmResponse{RecoveryCodes: codes})
+	}
+}
+
+// второй шаг входа: меняем pre-auth токен и код 2FA на сессию
+func (m *HandlerUserDB) LoginTwoFactor(ctx context.Context, log *zap.Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			log.Error("got request with bad method", zap.String("method", req.Method))
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			log.Error("wrong Content-Type", zap.String("method", req.Header.Get("Content-Type")))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		loginReq := &models.TwoFactorLoginRequest{}
+		dec := json.NewDecoder(req.Body)
+		if err := dec.Decode(loginReq); err != nil {
+			log.Error("cannot decode request JSON body", zap.Error(err))
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims, err := m.Keys.ParsePreAuthToken(loginReq.PreAuthToken)
+		if err != nil {
+			log.Error("invalid pre-auth token", zap.Error(err))
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		login := claims.Username
+		//коды 2FA перебираются так же, как пароли, поэтому учитываем попытки
+		ip := clientIP(req)
+		retryAfter, err := m.Lockout.Check(ctx, login, ip)
+		if err != nil {
+			log.Error("cannot check login attempts", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if retryAfter > 0 {
+			log.Error("too many failed login attempts", zap.String("login", login), zap.String("ip", ip))
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			res.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		ok, err := m.checkSecondFactor(ctx, login, loginReq)
+		if err != nil {
+			log.Error("cannot check second factor", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			log.Error("wrong second factor code")
+			m.loginFailed(ctx, log, login, ip)
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		err = m.Lockout.Success(ctx, login)
+		if err != nil {
+			log.Error("cannot reset login attempts", zap.Error(err))
+		}
+		userData := &models.UserData{Login: login}
+		err = m.StorageUsers.GetUser(ctx, userData)
+		if err != nil {
+			log.Error("cannot get user", zap.Error(err))
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		err = m.issueTokens(ctx, res, userData, "")
+		if err != nil {
+			log.Error("tokens not created", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// проверяем код TOTP (каждый код принимается один раз) или одноразовый код восстановления
+func (m *HandlerUserDB) checkSecondFactor(ctx context.Context, login string, loginReq *models.TwoFactorLoginRequest) (bool, error) {
+	if loginReq.RecoveryCode != "" {
+		return m.StorageUsers.UseRecoveryCode(ctx, login, auth.HashRecoveryCode(loginReq.RecoveryCode))
+	}
+	totp, err := m.StorageUsers.GetTOTP(ctx, login)
+	if err != nil {
+		return false, err
+	}
+	if !totp.Enabled {
+		return false, nil
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, loginReq.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return m.StorageUsers.UseTOTPStep(ctx, login, step)
+}
+
+// пишем ответ в json
+func writeJSON(res http.ResponseWriter, log *zap.Logger, status int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		log.Error("cannot marshal to json: ", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(response)
+}
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"github.com/MlDenis/internal/gofermart/auth"
 	"github.com/MlDenis/internal/gofermart/auth/cache"
 	"github.com/MlDenis/internal/gofermart/storage"
 )
@@ -9,11 +10,15 @@ import (
 type HandlerUserDB struct {
 	StorageUsers storage.InterfaceUser
 	DataJWT      *cache.DataJWT
+	Keys         *auth.KeySet
+	Lockout      *auth.Lockout
 }
 
-func HandlerUsers(users storage.InterfaceUser, DataJWT *cache.DataJWT) *HandlerUserDB {
+func HandlerUsers(users storage.InterfaceUser, DataJWT *cache.DataJWT, keys *auth.KeySet, lockout *auth.Lockout) *HandlerUserDB {
 	return &HandlerUserDB{
 		StorageUsers: users,
 		DataJWT:      DataJWT,
+		Keys:         keys,
+		Lockout:      lockout,
 	}
 }
@@ -3,13 +3,14 @@ package interactionwithaccrual
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/MlDenis/internal/gofermart/models"
 	"github.com/MlDenis/internal/gofermart/storage"
+	"github.com/MlDenis/pkg"
 	"go.uber.org/zap"
 )
 
@@ -36,13 +37,17 @@ func OrdersGoodsGorutine(ctx context.Context, ordersChan chan models.OrdersOnly,
 	rewards, err := s.GetAllOrders(ctx)
 	if err != nil {
 		log.Error("error in get rewards from db: ", zap.Error(err))
-		ordersChan <- models.OrdersOnly{OrderNumber: 0}
+		ordersChan <- models.OrdersOnly{OrderNumber: ""}
 		return
 
 	}
 
 	for _, order := range rewards {
-		err = s.EditStatusAndAccrualOrder(ctx, models.ProcessingOrder, 0, order.OrderNumber)
+		err = s.EditStatusAndAccrualOrder(ctx, models.ProcessingOrder, 0, order.OrderNumber, "")
+		if errors.Is(err, pkg.IllegalStatusTransition) {
+			//заказ уже обработан, пока мы читали список
+			continue
+		}
 		if err != nil {
 			log.Error("error in add accrual in db: ", zap.Error(err))
 			return
@@ -55,19 +60,24 @@ func OrdersGoodsGorutine(ctx context.Context, ordersChan chan models.OrdersOnly,
 func GetAccrualAndStatus(ctx context.Context, ordersChan chan models.OrdersOnly, s storage.Interface, url string, log *zap.Logger) {
 
 	order := <-ordersChan
-	if order.OrderNumber == 0 {
+	if order.OrderNumber == "" {
 		return
 	}
-	orderNumberToString := strconv.FormatInt(order.OrderNumber, 10)
-	urlGet := "http://" + url + "/api/orders/" + string(orderNumberToString)
+	urlGet := "http://" + url + "/api/orders/" + order.OrderNumber
 	resp, err := http.Get(urlGet)
 	if err != nil {
 		log.Error("connection refuser: ", zap.Error(err))
 		return
 	}
+	defer resp.Body.Close()
 
-	if resp.Status != strconv.Itoa(http.StatusAccepted) {
-		errorStatus := fmt.Sprintf("order with number %s does not exist", orderNumberToString)
+	//204 - заказ еще не зарегистрирован в системе расчета, спросим в следующий раз
+	if resp.StatusCode == http.StatusNoContent {
+		log.Info("order is not registered in accrual system", zap.String("order", order.OrderNumber))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorStatus := fmt.Sprintf("accrual system returned %s for order %s", resp.Status, order.OrderNumber)
 		log.Error(errorStatus)
 		return
 	}
@@ -77,12 +87,21 @@ func GetAccrualAndStatus(ctx context.Context, ordersChan chan models.OrdersOnly,
 		log.Error("cannot decode request JSON body: ", zap.Error(err))
 		return
 	}
-	err = s.EditStatusAndAccrualOrder(ctx, models.ProcessedOrder, orderResp.Accrual, order.OrderNumber)
-	if err != nil {
-		log.Error("error in add accrual in db: ", zap.Error(err))
+	switch orderResp.StatusOrder {
+	case models.InvalidOrder:
+		err = s.EditStatusAndAccrualOrder(ctx, models.InvalidOrder, 0, order.OrderNumber, "accrual system rejected the order")
+		if err != nil {
+			log.Error("error in add accrual in db: ", zap.Error(err))
+		}
+		return
+	case models.ProcessedOrder:
+	default:
+		//расчет еще не закончен, заказ остается в PROCESSING
 		return
 	}
-	err = s.EditBalanceAccrual(ctx, order.UserLogin, orderResp.Accrual)
+	//статус и начисление меняются одной транзакцией. Если переход в PROCESSED уже сделан
+	//другим обработчиком, получаем pkg.IllegalStatusTransition и повторно не начисляем
+	err = s.EditStatusAndAccrualOrder(ctx, models.ProcessedOrder, orderResp.Accrual, order.OrderNumber, "")
 	if err != nil {
 		log.Error("error in add accrual in db: ", zap.Error(err))
 		return
@@ -0,0 +1,19 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+   
+    CREATE TABLE IF NOT EXISTS sessions (
+            id INT GENERATED ALWAYS AS IDENTITY,
+            token TEXT NOT NULL,
+            userlogin TEXT NOT NULL,
+            expiresat TIMESTAMPTZ NOT NULL,
+            PRIMARY KEY(id),
+            UNIQUE(token)
+    );
+
+    CREATE INDEX IF NOT EXISTS sessions_expiresat_id ON sessions (expiresat);
+END $$;
+--
+--
+COMMIT TRANSACTION;
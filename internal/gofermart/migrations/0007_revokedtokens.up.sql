@@ -0,0 +1,22 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tokenid TEXT NOT NULL DEFAULT '';
+    CREATE INDEX IF NOT EXISTS sessions_userlogin_id ON sessions USING hash(userlogin);
+
+    CREATE TABLE IF NOT EXISTS revokedtokens (
+            id INT GENERATED ALWAYS AS IDENTITY,
+            tokenid TEXT NOT NULL,
+            userlogin TEXT NOT NULL,
+            expiresat TIMESTAMPTZ NOT NULL,
+            PRIMARY KEY(id),
+            UNIQUE(tokenid)
+    );
+
+    CREATE INDEX IF NOT EXISTS revokedtokens_expiresat_id ON revokedtokens (expiresat);
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,24 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    CREATE TABLE IF NOT EXISTS refreshtokens (
+            id INT GENERATED ALWAYS AS IDENTITY,
+            tokenhash TEXT NOT NULL,
+            familyid TEXT NOT NULL,
+            userlogin TEXT NOT NULL,
+            expiresat TIMESTAMPTZ NOT NULL,
+            used BOOLEAN NOT NULL DEFAULT false,
+            revoked BOOLEAN NOT NULL DEFAULT false,
+            PRIMARY KEY(id),
+            UNIQUE(tokenhash)
+    );
+
+    CREATE INDEX IF NOT EXISTS refreshtokens_familyid_id ON refreshtokens USING hash(familyid);
+    CREATE INDEX IF NOT EXISTS refreshtokens_userlogin_id ON refreshtokens USING hash(userlogin);
+    CREATE INDEX IF NOT EXISTS refreshtokens_expiresat_id ON refreshtokens (expiresat);
+END $$;
+--
+--
+COMMIT TRANSACTION;
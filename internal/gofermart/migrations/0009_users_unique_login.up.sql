@@ -0,0 +1,28 @@
+BEGIN TRANSACTION;
+
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+
+    -- раньше уникальной была только пара (userlogin, hashpass), с солеными хэшами логин должен быть уникален сам по себе.
+    -- если один логин успели зарегистрировать несколько раз, данные не удаляем: миграция падает
+    -- со списком дублей, их нужно разобрать вручную и запустить миграцию снова
+    SELECT string_agg(userlogin || ' (id ' || ids || ')', ', ')
+    INTO duplicates
+    FROM (
+        SELECT userlogin, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY userlogin
+        HAVING COUNT(*) > 1
+    ) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'duplicate user logins must be resolved manually before making userlogin unique: %', duplicates;
+    END IF;
+
+    CREATE UNIQUE INDEX IF NOT EXISTS users_userlogin_key ON users (userlogin);
+END $$;
+--
+--
+COMMIT TRANSACTION;
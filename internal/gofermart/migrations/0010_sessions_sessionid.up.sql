@@ -0,0 +1,10 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    ALTER TABLE sessions ADD COLUMN IF NOT EXISTS sessionid TEXT NOT NULL DEFAULT '';
+END $$;
+--
+--
+COMMIT TRANSACTION;
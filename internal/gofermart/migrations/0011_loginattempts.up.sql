@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    CREATE TABLE IF NOT EXISTS loginattempts (
+            id INT GENERATED ALWAYS AS IDENTITY,
+            attemptkey TEXT NOT NULL,
+            failures INT NOT NULL DEFAULT 0,
+            lastfailure TIMESTAMPTZ NOT NULL,
+            lockeduntil TIMESTAMPTZ,
+            PRIMARY KEY(id),
+            UNIQUE(attemptkey)
+    );
+END $$;
+--
+--
+COMMIT TRANSACTION;
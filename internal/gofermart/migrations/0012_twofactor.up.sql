@@ -0,0 +1,21 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS totpsecret TEXT;
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS totpenabled BOOLEAN NOT NULL DEFAULT false;
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS totplaststep BIGINT NOT NULL DEFAULT 0;
+
+    CREATE TABLE IF NOT EXISTS recoverycodes (
+            id INT GENERATED ALWAYS AS IDENTITY,
+            userlogin TEXT NOT NULL,
+            codehash TEXT NOT NULL,
+            usedat TIMESTAMPTZ,
+            PRIMARY KEY(id),
+            UNIQUE(userlogin, codehash)
+    );
+END $$;
+--
+--
+COMMIT TRANSACTION;
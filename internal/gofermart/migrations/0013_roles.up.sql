@@ -0,0 +1,13 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- первого администратора назначаем вручную: UPDATE users SET role = 'admin' WHERE userlogin = '...';
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+    ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+    ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'support', 'admin'));
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,60 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- журнал движения баллов, записи только добавляются.
+    -- каждая операция (txid) состоит из двух проводок с нулевой суммой:
+    -- счет пользователя (account = 'user') и системный счет (accrual, withdrawal, adjustment)
+    CREATE SEQUENCE IF NOT EXISTS ledger_txid_seq;
+
+    CREATE TABLE IF NOT EXISTS ledger (
+            id BIGINT GENERATED ALWAYS AS IDENTITY,
+            txid BIGINT NOT NULL,
+            userlogin TEXT NOT NULL,
+            account TEXT NOT NULL,
+            entrytype TEXT NOT NULL CHECK (entrytype IN ('accrual', 'withdrawal', 'adjustment', 'reversal')),
+            amount BIGINT NOT NULL,
+            ordernumber BIGINT,
+            reason TEXT,
+            createdat TIMESTAMP NOT NULL,
+            PRIMARY KEY(id)
+    );
+
+    CREATE INDEX IF NOT EXISTS ledger_userlogin_createdat_idx ON ledger (userlogin, createdat);
+    CREATE INDEX IF NOT EXISTS ledger_txid_idx ON ledger (txid);
+
+    -- переносим историю из заказов: начисления и списания
+    CREATE TEMPORARY TABLE ledger_backfill ON COMMIT DROP AS
+        SELECT nextval('ledger_txid_seq') AS txid, o.userlogin,
+               CASE WHEN o.statusorder = 'WITHDRAWEND' THEN 'withdrawal' ELSE 'accrual' END AS entrytype,
+               CASE WHEN o.statusorder = 'WITHDRAWEND' THEN -o.withdraw ELSE o.accrual END AS amount,
+               o.ordernumber, o.orderdate
+        FROM orders o
+        JOIN balance b ON b.userlogin = o.userlogin
+        WHERE (o.statusorder = 'WITHDRAWEND' AND COALESCE(o.withdraw, 0) > 0)
+           OR (o.statusorder = 'PROCESSED' AND COALESCE(o.accrual, 0) > 0);
+
+    INSERT INTO ledger (txid, userlogin, account, entrytype, amount, ordernumber, createdat)
+        SELECT txid, userlogin, 'user', entrytype, amount, ordernumber, orderdate FROM ledger_backfill
+        UNION ALL
+        SELECT txid, userlogin, entrytype, entrytype, -amount, ordernumber, orderdate FROM ledger_backfill;
+
+    -- расхождение счетчиков с историей заказов (ручные корректировки) фиксируем входящим остатком
+    CREATE TEMPORARY TABLE ledger_opening ON COMMIT DROP AS
+        SELECT nextval('ledger_txid_seq') AS txid, b.userlogin,
+               COALESCE(b.sumaccrual, 0) - COALESCE((SELECT SUM(l.amount) FROM ledger l WHERE l.userlogin = b.userlogin AND l.account = 'user'), 0) AS amount
+        FROM balance b;
+
+    INSERT INTO ledger (txid, userlogin, account, entrytype, amount, reason, createdat)
+        SELECT txid, userlogin, 'user', 'adjustment', amount, 'opening balance', now() FROM ledger_opening WHERE amount <> 0
+        UNION ALL
+        SELECT txid, userlogin, 'adjustment', 'adjustment', -amount, 'opening balance', now() FROM ledger_opening WHERE amount <> 0;
+
+    UPDATE balance b SET
+        sumaccrual = COALESCE(b.sumaccrual, 0),
+        sumwithdraw = COALESCE((SELECT -SUM(l.amount) FROM ledger l WHERE l.userlogin = b.userlogin AND l.account = 'user' AND l.entrytype IN ('withdrawal', 'reversal')), 0);
+END $$;
+--
+--
+COMMIT TRANSACTION;
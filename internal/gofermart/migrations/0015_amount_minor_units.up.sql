@@ -0,0 +1,17 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- суммы баллов и цены храним в сотых долях (см. пакет amount): 500.5 балла = 50050.
+    -- процент вознаграждения тоже хранится в сотых: 12.5% = 1250.
+    -- цены товаров в ordersaccrual.goods остаются десятичными числами в JSON и не меняются
+    UPDATE orders SET accrual = accrual * 100, withdraw = withdraw * 100;
+    UPDATE balance SET sumaccrual = sumaccrual * 100, sumwithdraw = sumwithdraw * 100;
+    UPDATE ledger SET amount = amount * 100;
+    UPDATE rewards SET reward = reward * 100;
+    UPDATE ordersaccrual SET accrual = accrual * 100;
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,25 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- ключи идемпотентности: отпечаток запроса и сохраненный ответ для повторов
+    CREATE TABLE IF NOT EXISTS idempotencykeys (
+            id INT GENERATED ALWAYS AS IDENTITY,
+            userlogin TEXT NOT NULL,
+            idemkey TEXT NOT NULL,
+            fingerprint TEXT NOT NULL,
+            completed BOOLEAN NOT NULL DEFAULT false,
+            statuscode INT,
+            contenttype TEXT,
+            body BYTEA,
+            createdat TIMESTAMPTZ NOT NULL,
+            PRIMARY KEY(id),
+            UNIQUE(userlogin, idemkey)
+    );
+
+    CREATE INDEX IF NOT EXISTS idempotencykeys_createdat_idx ON idempotencykeys (createdat);
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,42 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- партии начисленных баллов: списания расходуют их от старых к новым (FIFO),
+    -- остаток партии сгорает в expiresat (NULL - бессрочно)
+    CREATE TABLE IF NOT EXISTS accruallots (
+            id BIGINT GENERATED ALWAYS AS IDENTITY,
+            userlogin TEXT NOT NULL,
+            amount BIGINT NOT NULL,
+            remaining BIGINT NOT NULL CHECK (remaining >= 0),
+            createdat TIMESTAMP NOT NULL,
+            expiresat TIMESTAMP,
+            PRIMARY KEY(id)
+    );
+
+    CREATE INDEX IF NOT EXISTS accruallots_userlogin_idx ON accruallots (userlogin) WHERE remaining > 0;
+    CREATE INDEX IF NOT EXISTS accruallots_expiresat_idx ON accruallots (expiresat) WHERE remaining > 0;
+
+    ALTER TABLE ledger DROP CONSTRAINT IF EXISTS ledger_entrytype_check;
+    ALTER TABLE ledger ADD CONSTRAINT ledger_entrytype_check
+        CHECK (entrytype IN ('accrual', 'withdrawal', 'adjustment', 'reversal', 'expiry'));
+
+    -- текущий остаток раскладываем по последним поступлениям: старые уже израсходованы по FIFO.
+    -- баллы, начисленные до введения срока действия, не сгорают
+    INSERT INTO accruallots (userlogin, amount, remaining, createdat, expiresat)
+        SELECT c.userlogin, c.amount,
+               LEAST(c.amount, GREATEST(0, b.sumaccrual - (c.newer - c.amount))),
+               c.createdat, NULL
+        FROM (
+            SELECT l.userlogin, l.amount, l.createdat,
+                   SUM(l.amount) OVER (PARTITION BY l.userlogin ORDER BY l.createdat DESC, l.id DESC) AS newer
+            FROM ledger l
+            WHERE l.account = 'user' AND l.amount > 0
+        ) c
+        JOIN balance b ON b.userlogin = c.userlogin
+        WHERE b.sumaccrual - (c.newer - c.amount) > 0;
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- переводы баллов между пользователями: у каждой стороны своя операция
+    -- через системный счет transfer, counterparty - логин другой стороны
+    ALTER TABLE ledger ADD COLUMN IF NOT EXISTS counterparty TEXT;
+
+    ALTER TABLE ledger DROP CONSTRAINT IF EXISTS ledger_entrytype_check;
+    ALTER TABLE ledger ADD CONSTRAINT ledger_entrytype_check
+        CHECK (entrytype IN ('accrual', 'withdrawal', 'adjustment', 'reversal', 'expiry', 'transfer_in', 'transfer_out'));
+
+    CREATE INDEX IF NOT EXISTS ledger_userlogin_entrytype_idx ON ledger (userlogin, entrytype, createdat);
+END $$;
+--
+--
+COMMIT TRANSACTION;
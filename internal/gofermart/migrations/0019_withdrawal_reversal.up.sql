@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- возврат списания: исходная запись остается, отмечаем время и причину возврата
+    ALTER TABLE orders ADD COLUMN IF NOT EXISTS reversedat TIMESTAMP;
+    ALTER TABLE orders ADD COLUMN IF NOT EXISTS reversereason TEXT;
+END $$;
+--
+--
+COMMIT TRANSACTION;
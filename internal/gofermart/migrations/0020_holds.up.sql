@@ -0,0 +1,30 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- резерв баллов на время оплаты: уменьшает доступный, но не общий баланс.
+    -- резерв либо списывается (CAPTURED), либо снимается (RELEASED, EXPIRED)
+    ALTER TABLE balance ADD COLUMN IF NOT EXISTS sumheld BIGINT NOT NULL DEFAULT 0;
+
+    CREATE TABLE IF NOT EXISTS holds (
+            id BIGINT GENERATED ALWAYS AS IDENTITY,
+            holdid TEXT NOT NULL,
+            userlogin TEXT NOT NULL,
+            ordernumber BIGINT NOT NULL,
+            amount BIGINT NOT NULL CHECK (amount > 0),
+            status TEXT NOT NULL CHECK (status IN ('HELD', 'CAPTURED', 'RELEASED', 'EXPIRED')),
+            createdat TIMESTAMP NOT NULL,
+            expiresat TIMESTAMP NOT NULL,
+            closedat TIMESTAMP,
+            PRIMARY KEY(id),
+            UNIQUE(holdid)
+    );
+
+    -- по одному заказу может быть только один действующий резерв
+    CREATE UNIQUE INDEX IF NOT EXISTS holds_active_ordernumber_idx ON holds (ordernumber) WHERE status = 'HELD';
+    CREATE INDEX IF NOT EXISTS holds_active_expiresat_idx ON holds (expiresat) WHERE status = 'HELD';
+END $$;
+--
+--
+COMMIT TRANSACTION;
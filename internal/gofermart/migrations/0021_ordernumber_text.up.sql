@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- номера заказов партнеров бывают длиннее 19 цифр и не помещаются в BIGINT,
+    -- храним их строкой цифр. Индексы по ordernumber перестраиваются вместе с колонкой
+    ALTER TABLE orders ALTER COLUMN ordernumber TYPE TEXT USING ordernumber::text;
+    ALTER TABLE ordersaccrual ALTER COLUMN ordernumber TYPE TEXT USING ordernumber::text;
+    ALTER TABLE ledger ALTER COLUMN ordernumber TYPE TEXT USING ordernumber::text;
+    ALTER TABLE holds ALTER COLUMN ordernumber TYPE TEXT USING ordernumber::text;
+
+    ALTER TABLE orders ADD CONSTRAINT orders_ordernumber_digits CHECK (ordernumber ~ '^[0-9]+$');
+    ALTER TABLE ordersaccrual ADD CONSTRAINT ordersaccrual_ordernumber_digits CHECK (ordernumber ~ '^[0-9]+$');
+END $$;
+--
+--
+COMMIT TRANSACTION;
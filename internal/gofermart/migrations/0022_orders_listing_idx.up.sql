@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- список заказов пользователя: фильтр по логину, сортировка и курсор по (orderdate, id)
+    CREATE INDEX IF NOT EXISTS orders_userlogin_orderdate_idx ON orders (userlogin, orderdate, id);
+END $$;
+--
+--
+COMMIT TRANSACTION;
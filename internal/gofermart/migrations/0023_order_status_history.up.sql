@@ -0,0 +1,28 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- журнал смены статусов заказов на начисление: строка на каждый переход,
+    -- fromstatus NULL - заказ только что загружен
+    CREATE TABLE IF NOT EXISTS order_status_history (
+            id BIGINT GENERATED ALWAYS AS IDENTITY,
+            ordernumber TEXT NOT NULL,
+            fromstatus TEXT,
+            tostatus TEXT NOT NULL,
+            reason TEXT,
+            changedat TIMESTAMP NOT NULL,
+            PRIMARY KEY(id)
+    );
+
+    CREATE INDEX IF NOT EXISTS order_status_history_ordernumber_idx ON order_status_history (ordernumber, changedat, id);
+
+    -- история до введения журнала не сохранилась, для существующих заказов пишем текущий статус
+    INSERT INTO order_status_history (ordernumber, fromstatus, tostatus, reason, changedat)
+        SELECT ordernumber, NULL, statusorder, 'status before history was recorded', orderdate
+        FROM orders
+        WHERE statusorder <> 'WITHDRAWEND';
+END $$;
+--
+--
+COMMIT TRANSACTION;
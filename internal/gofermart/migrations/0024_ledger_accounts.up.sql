@@ -0,0 +1,33 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- строки журнала неизменяемы, поэтому ссылаются не на логин, а на счет пользователя.
+    -- при удалении аккаунта обезличивается только логин в ledgeraccounts
+    CREATE TABLE IF NOT EXISTS ledgeraccounts (
+            id BIGINT GENERATED ALWAYS AS IDENTITY,
+            userlogin TEXT NOT NULL,
+            PRIMARY KEY(id),
+            UNIQUE(userlogin)
+    );
+
+    INSERT INTO ledgeraccounts (userlogin)
+        SELECT userlogin FROM ledger
+        UNION
+        SELECT userlogin FROM balance
+        ON CONFLICT (userlogin) DO NOTHING;
+
+    ALTER TABLE ledger ADD COLUMN IF NOT EXISTS accountid BIGINT REFERENCES ledgeraccounts (id);
+    UPDATE ledger l SET accountid = a.id FROM ledgeraccounts a WHERE a.userlogin = l.userlogin;
+    ALTER TABLE ledger ALTER COLUMN accountid SET NOT NULL;
+
+    -- индексы по userlogin удаляются вместе с колонкой
+    ALTER TABLE ledger DROP COLUMN userlogin;
+
+    CREATE INDEX IF NOT EXISTS ledger_accountid_createdat_idx ON ledger (accountid, createdat);
+    CREATE INDEX IF NOT EXISTS ledger_accountid_entrytype_idx ON ledger (accountid, entrytype, createdat);
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,27 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- вторая сторона перевода тоже хранится ссылкой на счет, а не логином,
+    -- чтобы после удаления аккаунта его логин не оставался в выписках других пользователей
+    ALTER TABLE ledger ADD COLUMN IF NOT EXISTS counterpartyid BIGINT REFERENCES ledgeraccounts (id);
+
+    -- логины, для которых счета уже нет, принадлежали удаленным пользователям:
+    -- заводим им обезличенные счета
+    CREATE TEMPORARY TABLE deletedcounterparties ON COMMIT DROP AS
+        SELECT c.counterparty, 'deleted:' || md5(random()::text || c.counterparty) AS anonymized
+        FROM (SELECT DISTINCT counterparty FROM ledger WHERE counterparty IS NOT NULL) c
+        WHERE NOT EXISTS (SELECT 1 FROM ledgeraccounts a WHERE a.userlogin = c.counterparty);
+    INSERT INTO ledgeraccounts (userlogin) SELECT anonymized FROM deletedcounterparties;
+
+    UPDATE ledger l SET counterpartyid = a.id
+        FROM ledgeraccounts a
+        LEFT JOIN deletedcounterparties d ON d.anonymized = a.userlogin
+        WHERE COALESCE(d.counterparty, a.userlogin) = l.counterparty;
+
+    ALTER TABLE ledger DROP COLUMN counterparty;
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,22 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- из каких партий списаны баллы по заказу: при возврате списания баллы возвращаются
+    -- в партии с прежними временем поступления и сроком сгорания, а не начинают срок заново.
+    -- для списаний до этой миграции записей нет, их возврат создает новую партию
+    CREATE TABLE IF NOT EXISTS withdrawnlots (
+            id BIGINT GENERATED ALWAYS AS IDENTITY,
+            ordernumber TEXT NOT NULL,
+            amount BIGINT NOT NULL CHECK (amount > 0),
+            createdat TIMESTAMP NOT NULL,
+            expiresat TIMESTAMP,
+            PRIMARY KEY(id)
+    );
+
+    CREATE INDEX IF NOT EXISTS withdrawnlots_ordernumber_idx ON withdrawnlots (ordernumber);
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -0,0 +1,16 @@
+BEGIN TRANSACTION;
+
+DO $$
+BEGIN
+
+    -- номера приходят с ведущими нулями и без, обработчики убирают нули перед записью,
+    -- как было, пока номер хранился в BIGINT. Запрещаем ведущие нули и в бд, чтобы
+    -- 0123 и 123 не стали двумя разными заказами
+    ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_ordernumber_digits;
+    ALTER TABLE ordersaccrual DROP CONSTRAINT IF EXISTS ordersaccrual_ordernumber_digits;
+    ALTER TABLE orders ADD CONSTRAINT orders_ordernumber_digits CHECK (ordernumber ~ '^(0|[1-9][0-9]*)$');
+    ALTER TABLE ordersaccrual ADD CONSTRAINT ordersaccrual_ordernumber_digits CHECK (ordernumber ~ '^(0|[1-9][0-9]*)$');
+END $$;
+--
+--
+COMMIT TRANSACTION;
@@ -1,55 +1,342 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/ordernumber"
+)
 
 const HeaderHTTP = "Authorization"
+const BearerPrefix = "Bearer "
+const CookieToken = "token"
+const HeaderRefresh = "X-Refresh-Token"
+const HeaderIdempotencyKey = "Idempotency-Key"
+const HeaderIdempotentReplay = "Idempotent-Replayed"
+const HeaderNextCursor = "X-Next-Cursor"
+const HeaderAPIVersion = "X-API-Version"
+
+// Версии формата ответов: v1 - прежний формат, v2 - формат из спецификации
+const (
+	APIVersionLegacy = "v1"
+	APIVersionSpec   = "v2"
+)
 
 // Структура данных для пользователя
 type UserData struct {
 	Login        string `json:"login"`
 	Password     string `json:"password"`
-	PasswordHash string `json:"passwordhash"`
+	PasswordHash string `json:"-"`
 	Token        string `json:"token"`
+	TokenID      string `json:"-"`
+	SessionID    string `json:"-"`
+	Role         string `json:"-"`
+}
+
+// Префикс логина, под которым остаются заказы и журнал удаленного аккаунта (см. storage.DeleteUser).
+// При регистрации такие логины запрещены, иначе новый пользователь получил бы чужие данные
+const DeletedLoginPrefix = "deleted:"
+
+// обезличенный логин удаленного пользователя
+func DeletedLogin(userID int64) string {
+	return DeletedLoginPrefix + strconv.FormatInt(userID, 10)
+}
+
+// логин из пространства обезличенных, зарегистрировать его нельзя
+func IsReservedLogin(login string) bool {
+	return strings.HasPrefix(login, DeletedLoginPrefix)
+}
+
+// Структура сессии пользователя
+type Session struct {
+	Token     string    `json:"token"`
+	TokenID   string    `json:"token_id"`
+	SessionID string    `json:"session_id"`
+	Login     string    `json:"login"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Структура отозванного токена, храним до истечения его срока действия
+type RevokedToken struct {
+	TokenID   string    `json:"token_id"`
+	Login     string    `json:"login"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Структура refresh-токена, все токены одной сессии образуют семейство FamilyID
+type RefreshToken struct {
+	TokenHash string    `json:"token_hash"`
+	FamilyID  string    `json:"family_id"`
+	Login     string    `json:"login"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// Структура запроса на обновление токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Структура запроса на смену пароля
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// Структура запроса на удаление аккаунта, пароль подтверждает намерение
+type DeleteUserRequest struct {
+	Password string `json:"password"`
+}
+
+// Настройки двухфакторной аутентификации пользователя
+type TOTP struct {
+	Secret   string `json:"-"`
+	Enabled  bool   `json:"enabled"`
+	LastStep int64  `json:"-"`
+}
+
+// Ответ на подключение 2FA: секрет и ссылка для приложения-аутентификатора
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// Запрос с кодом из приложения-аутентификатора
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// Ответ на подтверждение 2FA, коды восстановления показываются один раз
+type TOTPConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Ответ на вход по паролю, если нужен второй шаг
+type PreAuthResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	PreAuthToken      string `json:"pre_auth_token"`
+}
+
+// Запрос второго шага входа: код TOTP или код восстановления
+type TwoFactorLoginRequest struct {
+	PreAuthToken string `json:"pre_auth_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Запрос на ручную корректировку баланса, Sum может быть отрицательной
+type AdjustBalanceRequest struct {
+	Sum    amount.Amount `json:"sum"`
+	Reason string        `json:"reason"`
+}
+
+// Запрос на смену роли пользователя
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// Сохраненный результат запроса с ключом идемпотентности
+type IdempotencyRecord struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// Открытый ключ подписи токенов в формате JWK (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// Набор открытых ключей для других сервисов, проверяющих наши токены
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
 }
 
 // Структура заказов для пользователя
 type Orders struct {
 	UserLogin string `json:"user_login"`
 	OrdersOnly
-	Accrual  int64 `json:"accrual"`
-	Withdraw int64 `json:"withdraw"`
+	Accrual  amount.Amount `json:"accrual"`
+	Withdraw amount.Amount `json:"withdraw"`
 }
 
 type OrdersOnly struct {
-	OrderNumber int64     `json:"order_number"`
+	ID          int64     `json:"-"`
+	OrderNumber string    `json:"order_number"`
 	OrderDate   time.Time `json:"order_date"`
 	StatusOrder string    `json:"status_order"`
 	UserLogin   string    `json:"user_login"`
 }
 
+// Заказ в списке заказов в формате спецификации (API v2).
+// Accrual есть только у заказов в статусе PROCESSED
+type OrderListItem struct {
+	Number     string         `json:"number"`
+	Status     string         `json:"status"`
+	Accrual    *amount.Amount `json:"accrual,omitempty"`
+	UploadedAt string         `json:"uploaded_at"`
+}
+
+// Смена статуса заказа, From пустой у только что загруженного заказа
+type OrderStatusChange struct {
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Заказ с историей смены статусов
+type OrderDetails struct {
+	OrderListItem
+	History []OrderStatusChange `json:"history"`
+}
+
+// Фильтр списка заказов: статусы, период загрузки [From, To), порядок сортировки
+// и позиция, после которой продолжаем (курсор). Limit 0 - без ограничения
+type OrdersFilter struct {
+	Statuses  []string
+	From      *time.Time
+	To        *time.Time
+	Desc      bool
+	AfterTime *time.Time
+	AfterID   int64
+	Limit     int
+}
+
 // Структура баланса пользователя
 type Balance struct {
-	UserLogin   string `json:"user_login"`
-	AccrualSum  int64  `json:"accrual_sum"`
-	WithdrawSum int64  `json:"withdraw_sum"`
+	UserLogin   string        `json:"user_login"`
+	AccrualSum  amount.Amount `json:"accrual_sum"`
+	WithdrawSum amount.Amount `json:"withdraw_sum"`
+}
+
+// время в бд хранится без зоны по часам сервера, а pgx читает его как UTC,
+// поэтому перед отдачей клиенту ставим локальную зону с теми же часами
+func ServerTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
 }
 
 // Структура баланса пдя ответа на запрос GetBalance
 type ResponseBalance struct {
-	AccrualSum  int64 `json:"accrual_sum"`
-	WithdrawSum int64 `json:"withdraw_sum"`
+	AccrualSum   amount.Amount `json:"accrual_sum"`
+	WithdrawSum  amount.Amount `json:"withdraw_sum"`
+	Available    amount.Amount `json:"available"`
+	Held         amount.Amount `json:"held"`
+	ExpiringSoon amount.Amount `json:"expiring_soon"`
+	NextExpiry   *time.Time    `json:"next_expiry,omitempty"`
 }
 
+// номер заказа в теле запроса: строка цифр, но по спецификации его присылают и числом
+type OrderNumber = ordernumber.Number
+
 // Структура баланса для ответа на запрос на списание средств
 type WithdrawOrder struct {
-	Order       int64     `json:"order"`
-	Sum         int64     `json:"sum"`
-	ProcessedAt time.Time `json:"processed_at,omitempty"`
+	Order       OrderNumber   `json:"order,omitempty"`
+	Sum         amount.Amount `json:"sum"`
+	ProcessedAt time.Time     `json:"processed_at,omitempty"`
+	//для перевода другому пользователю: тип transfer и получатель
+	Type         string `json:"type,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+	//списание возвращено администратором
+	ReversedAt *time.Time `json:"reversed_at,omitempty"`
 }
+
+// Резерв баллов под оплату заказа
+type Hold struct {
+	HoldID      string        `json:"hold_id"`
+	UserLogin   string        `json:"-"`
+	OrderNumber string        `json:"order"`
+	Sum         amount.Amount `json:"sum"`
+	Status      string        `json:"status"`
+	CreatedAt   time.Time     `json:"created_at"`
+	ExpiresAt   time.Time     `json:"expires_at"`
+	ClosedAt    *time.Time    `json:"closed_at,omitempty"`
+}
+
+// Запрос на резервирование баллов
+type HoldRequest struct {
+	Order OrderNumber   `json:"order"`
+	Sum   amount.Amount `json:"sum"`
+}
+
+// Запрос на возврат списания, причина попадает в журнал
+type ReverseWithdrawalRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Запрос на перевод баллов другому пользователю
+type TransferRequest struct {
+	To      string        `json:"to"`
+	Sum     amount.Amount `json:"sum"`
+	Comment string        `json:"comment"`
+}
+
+// Проводка журнала баллов, Amount со знаком: начисление положительное, списание отрицательное
+type LedgerEntry struct {
+	UserLogin   string        `json:"user_login"`
+	EntryType   string        `json:"entry_type"`
+	Amount      amount.Amount `json:"amount"`
+	OrderNumber string        `json:"order_number,omitempty"`
+	Reason      string        `json:"reason,omitempty"`
+	//логин второй стороны перевода, в журнале хранится ее счет
+	Counterparty string    `json:"counterparty,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Строка выписки по баллам: операция и остаток после нее
+type BalanceHistoryEntry struct {
+	ID           int64         `json:"-"`
+	EntryType    string        `json:"type"`
+	Amount       amount.Amount `json:"amount"`
+	Balance      amount.Amount `json:"balance"`
+	OrderNumber  string        `json:"order,omitempty"`
+	Reason       string        `json:"reason,omitempty"`
+	Counterparty string        `json:"counterparty,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// Фильтр выписки: период [From, To) и позиция, после которой продолжаем (курсор)
+type BalanceHistoryFilter struct {
+	From      *time.Time
+	To        *time.Time
+	AfterTime *time.Time
+	AfterID   int64
+	Limit     int
+}
+
+// Страница выписки, NextCursor пустой на последней странице
+type BalanceHistoryResponse struct {
+	Entries    []BalanceHistoryEntry `json:"entries"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// Расхождение, найденное при сверке баланса с журналом
+type LedgerMismatch struct {
+	UserLogin       string        `json:"user_login,omitempty"`
+	TxID            int64         `json:"tx_id,omitempty"`
+	BalanceSum      amount.Amount `json:"balance_sum"`
+	LedgerSum       amount.Amount `json:"ledger_sum"`
+	BalanceWithdraw amount.Amount `json:"balance_withdraw"`
+	LedgerWithdraw  amount.Amount `json:"ledger_withdraw"`
+}
+
 type OrderResp struct {
-	OrderNumber int64  `json:"order_number"`
-	StatusOrder string `json:"status_order"`
-	Accrual     int64  `json:"accrual"`
+	OrderNumber OrderNumber   `json:"order_number"`
+	StatusOrder string        `json:"status_order"`
+	Accrual     amount.Amount `json:"accrual"`
 }
 
 const (
@@ -59,6 +346,42 @@ const (
 	ProcessedOrder  = "PROCESSED"
 	WithdrawEnd     = "WITHDRAWEND" //Статус заказа на списание, этот заказ не будет ждать начисления баллов
 )
+
+// Статусы резерва баллов
+const (
+	HoldHeld     = "HELD"
+	HoldCaptured = "CAPTURED"
+	HoldReleased = "RELEASED"
+	HoldExpired  = "EXPIRED" //снят автоматически по таймауту
+)
+
+// Типы проводок журнала баллов
+const (
+	EntryAccrual     = "accrual"
+	EntryWithdrawal  = "withdrawal"
+	EntryAdjustment  = "adjustment"
+	EntryReversal    = "reversal" //возврат списанных баллов
+	EntryExpiry      = "expiry"   //сгорание баллов по сроку действия
+	EntryTransferIn  = "transfer_in"
+	EntryTransferOut = "transfer_out"
+)
+
+// Счет пользователя в журнале, остальные счета системные и называются по типу операции
+const LedgerUserAccount = "user"
+
+// Системный счет переводов между пользователями
+const LedgerTransferAccount = "transfer"
+
+// Тип записи в списке списаний для перевода другому пользователю
+const WithdrawTypeTransfer = "transfer"
+
 const (
 	BalanceAuthAccrualWithdraw = 0 //баланс при авторизации пользователей назначаем 0
 )
+
+// Роли пользователей: обычный пользователь, поддержка (только просмотр) и администратор
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOrderNumberUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    OrderNumber
+		wantErr bool
+	}{
+		{body: `{"order": "12345678903"}`, want: "12345678903"},
+		{body: `{"order": 12345678903}`, want: "12345678903"},
+		{body: `{"order": 123456789012345678901234567890}`, want: "123456789012345678901234567890"},
+		{body: `{"order": null}`, want: ""},
+		{body: `{"order": 1.5}`, wantErr: true},
+		{body: `{"order": -5}`, wantErr: true},
+		{body: `{"order": 1e3}`, wantErr: true},
+		{body: `{"order": true}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		req := HoldRequest{}
+		err := json.Unmarshal([]byte(tt.body), &req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got order %q", tt.body, req.Order)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.body, err)
+			continue
+		}
+		if req.Order != tt.want {
+			t.Errorf("%s: order = %q, want %q", tt.body, req.Order, tt.want)
+		}
+	}
+}
+
+// часы из бд сохраняются, меняется только зона
+func TestServerTime(t *testing.T) {
+	stored := time.Date(2023, 9, 1, 12, 30, 0, 0, time.UTC)
+	got := ServerTime(stored)
+	if got.Location() != time.Local {
+		t.Errorf("location = %v, want Local", got.Location())
+	}
+	if got.Format("2006-01-02 15:04:05") != "2023-09-01 12:30:00" {
+		t.Errorf("wall clock = %s, want 2023-09-01 12:30:00", got.Format("2006-01-02 15:04:05"))
+	}
+}
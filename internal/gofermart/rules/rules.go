@@ -0,0 +1,81 @@
+// Package rules - правила списания баллов: минимум и максимум за операцию,
+// дневной и месячный лимиты пользователя. Нулевое значение лимита отключает правило.
+// Проверка выполняется в транзакции списания, после блокировки строки баланса.
+package rules
+
+import (
+	"fmt"
+
+	"github.com/MlDenis/internal/amount"
+)
+
+// коды нарушений, отдаются клиенту в поле error
+const (
+	InvalidAmount        = "invalid_amount"
+	BelowMinimum         = "below_minimum"
+	AboveMaximum         = "above_maximum"
+	DailyLimitExceeded   = "daily_limit_exceeded"
+	MonthlyLimitExceeded = "monthly_limit_exceeded"
+)
+
+// настройки лимитов списания
+type Limits struct {
+	MinPerTx amount.Amount
+	MaxPerTx amount.Amount
+	Daily    amount.Amount
+	Monthly  amount.Amount
+}
+
+// сколько пользователь уже списал (и зарезервировал) за текущие сутки и месяц
+type Usage struct {
+	Daily   amount.Amount
+	Monthly amount.Amount
+}
+
+// нарушенное правило, сериализуется в тело ответа
+type Violation struct {
+	Rule      string         `json:"error"`
+	Message   string         `json:"message"`
+	Limit     *amount.Amount `json:"limit,omitempty"`
+	Remaining *amount.Amount `json:"remaining,omitempty"`
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// проверяем сумму списания по всем правилам, возвращаем первое нарушенное
+func (l Limits) Evaluate(sum amount.Amount, usage Usage) *Violation {
+	if sum <= 0 {
+		return &Violation{Rule: InvalidAmount, Message: "withdrawal sum must be positive"}
+	}
+	if l.MinPerTx > 0 && sum < l.MinPerTx {
+		return &Violation{Rule: BelowMinimum, Message: fmt.Sprintf("minimum withdrawal is %s", l.MinPerTx), Limit: ptr(l.MinPerTx)}
+	}
+	if l.MaxPerTx > 0 && sum > l.MaxPerTx {
+		return &Violation{Rule: AboveMaximum, Message: fmt.Sprintf("maximum withdrawal is %s", l.MaxPerTx), Limit: ptr(l.MaxPerTx)}
+	}
+	if l.Daily > 0 && usage.Daily+sum > l.Daily {
+		return &Violation{Rule: DailyLimitExceeded, Message: fmt.Sprintf("daily withdrawal limit is %s", l.Daily), Limit: ptr(l.Daily), Remaining: ptr(remaining(l.Daily, usage.Daily))}
+	}
+	if l.Monthly > 0 && usage.Monthly+sum > l.Monthly {
+		return &Violation{Rule: MonthlyLimitExceeded, Message: fmt.Sprintf("monthly withdrawal limit is %s", l.Monthly), Limit: ptr(l.Monthly), Remaining: ptr(remaining(l.Monthly, usage.Monthly))}
+	}
+	return nil
+}
+
+// нужно ли считать использование лимитов (иначе лишний запрос в бд)
+func (l Limits) NeedUsage() bool {
+	return l.Daily > 0 || l.Monthly > 0
+}
+
+func remaining(limit, used amount.Amount) amount.Amount {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+func ptr(a amount.Amount) *amount.Amount {
+	return &a
+}
@@ -2,8 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/MlDenis/internal/amount"
 	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5"
 )
 
 // записываем данные нового пользователя в бд
@@ -26,20 +31,218 @@ func (pgdb *PostgresDB) RegisterUser(ctx context.Context, userData models.UserDa
 
 }
 
-// проверям есть ли пользователь в бд
+// проверям есть ли пользователь в бд и получаем хэш его пароля и роль
 func (pgdb *PostgresDB) GetUser(ctx context.Context, userData *models.UserData) error {
+	row := pgdb.pool.QueryRow(ctx, "SELECT hashpass, role FROM public.users WHERE userlogin=$1", userData.Login)
+	return row.Scan(&userData.PasswordHash, &userData.Role)
+}
+
+// меняем роль пользователя. Роль передается в токенах, поэтому в той же транзакции
+// отзываем все токены: со старой ролью пользователь больше не пройдет проверку
+func (pgdb *PostgresDB) SetUserRole(ctx context.Context, userlogin string, role string) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE public.users SET role = $1 WHERE userlogin=$2`, role, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+
+		tx.Rollback(ctx)
+		return pkg.UserNotExist
+	}
+	err = revokeAllTokens(ctx, tx, userlogin, "")
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// меняем хэш пароля пользователя
+func (pgdb *PostgresDB) UpdatePasswordHash(ctx context.Context, userlogin string, hash string) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE public.users SET hashpass = $1 WHERE userlogin=$2`, hash, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// удаляем аккаунт пользователя. Политика: остаток баланса сгорает, заказы и списания
+// не удаляются (номера заказов уникальны и нужны для учета), а обезличиваются -
+// вместо логина записывается deleted:<id пользователя> (models.DeletedLogin)
+func (pgdb *PostgresDB) DeleteUser(ctx context.Context, userlogin string) error {
 	tx, err := pgdb.pool.Begin(ctx)
 	if err != nil {
 
 		return err
 	}
-	var userId int64
-	row := pgdb.pool.QueryRow(ctx, "SELECT id FROM public.users WHERE userlogin=$1", userData.Login)
-	err = row.Scan(&userId)
+
+	//действующие резервы снимаем, иначе закрывающая проводка их не затронет
+	_, err = tx.Exec(ctx,
+		`UPDATE public.holds SET status = $1, closedat = $2 WHERE userlogin=$3 AND status = $4`,
+		models.HoldReleased, time.Now(), userlogin, models.HoldHeld,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.balance SET sumheld = 0 WHERE userlogin=$1`, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	//остаток баллов сгорает при закрытии аккаунта, фиксируем это в журнале
+	var remaining amount.Amount
+	row := tx.QueryRow(ctx, `SELECT sumaccrual FROM public.balance WHERE userlogin=$1 FOR UPDATE`, userlogin)
+	err = row.Scan(&remaining)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	if remaining > 0 {
+		err = pgdb.postEntry(ctx, tx, models.LedgerEntry{
+			UserLogin: userlogin,
+			EntryType: models.EntryAdjustment,
+			Amount:    -remaining,
+			Reason:    "account closed",
+		})
+		if err != nil {
+
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+	var userID int64
+	row = tx.QueryRow(ctx, `DELETE FROM public.users WHERE userlogin=$1 RETURNING id`, userlogin)
+	err = row.Scan(&userID)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	//необработанные заказы закрываем: начислять баллы уже некуда, и обработчик начислений
+	//повторял бы их бесконечно
+	now := time.Now()
+	rows, err := tx.Query(ctx,
+		`UPDATE public.orders o SET statusorder = $1
+		FROM (SELECT ordernumber, statusorder FROM public.orders WHERE userlogin=$2 AND statusorder IN ($3, $4) FOR UPDATE) p
+		WHERE o.ordernumber = p.ordernumber
+		RETURNING o.ordernumber, p.statusorder`,
+		models.InvalidOrder, userlogin, models.NewOrder, models.ProcessingOrder,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	type pendingOrder struct {
+		number string
+		status string
+	}
+	pending := []pendingOrder{}
+	for rows.Next() {
+		var order pendingOrder
+		err = rows.Scan(&order.number, &order.status)
+		if err != nil {
+			rows.Close()
+			tx.Rollback(ctx)
+			return err
+		}
+		pending = append(pending, order)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	for _, order := range pending {
+		err = pgdb.recordOrderStatus(ctx, tx, order.number, order.status, models.InvalidOrder, "account closed", now)
+		if err != nil {
+
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+	anonymizedLogin := models.DeletedLogin(userID)
+	_, err = tx.Exec(ctx, `UPDATE public.orders SET userlogin = $1 WHERE userlogin=$2`, anonymizedLogin, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	//журнал не меняем: его строки ссылаются на счет, обезличиваем только логин счета
+	_, err = tx.Exec(ctx, `UPDATE public.ledgeraccounts SET userlogin = $1 WHERE userlogin=$2`, anonymizedLogin, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.accruallots SET userlogin = $1 WHERE userlogin=$2`, anonymizedLogin, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.holds SET userlogin = $1 WHERE userlogin=$2`, anonymizedLogin, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM public.balance WHERE userlogin=$1`, userlogin)
 	if err != nil {
 
 		tx.Rollback(ctx)
 		return err
 	}
+	_, err = tx.Exec(ctx, `DELETE FROM public.recoverycodes WHERE userlogin=$1`, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	//сессии отзываем в той же транзакции: аккаунт не может исчезнуть с живыми токенами
+	err = revokeAllTokens(ctx, tx, userlogin, "")
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	//сохраненные ответы содержат данные пользователя, счетчики входа привязаны к логину (ключ login:<логин>, см. auth.Lockout)
+	_, err = tx.Exec(ctx, `DELETE FROM public.idempotencykeys WHERE userlogin=$1`, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM public.loginattempts WHERE attemptkey=$1`, "login:"+userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
 	return tx.Commit(ctx)
 }
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/models"
+)
+
+// смена роли отзывает токены пользователя: старая роль в токене больше не действует
+func TestSetUserRoleRevokesTokens(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	login := testLogin()
+	err := pgdb.RegisterUser(ctx, models.UserData{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	err = pgdb.AddSession(ctx, models.Session{Token: login + "-token", TokenID: login + "-id", SessionID: login + "-session", Login: login, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pgdb.AddRefreshToken(ctx, models.RefreshToken{TokenHash: login + "-refresh", FamilyID: login + "-session", Login: login, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pgdb.SetUserRole(ctx, login, models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := pgdb.IsTokenRevoked(ctx, login+"-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Error("access token is not revoked after role change")
+	}
+	refresh, err := pgdb.GetRefreshToken(ctx, login+"-refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refresh.Revoked {
+		t.Error("refresh token is not revoked after role change")
+	}
+}
+
+// удаление аккаунта закрывает необработанные заказы: иначе обработчик начислений
+// бесконечно пытался бы начислить баллы на удаленный баланс
+func TestDeleteUserClosesPendingOrders(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	login := testLogin()
+	number := testOrderNumber()
+	err := pgdb.RegisterUser(ctx, models.UserData{Login: login, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestUser(t, pgdb, login, 0)
+	err = pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: login, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pgdb.EditStatusAndAccrualOrder(ctx, models.ProcessingOrder, 0, number, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pgdb.DeleteUser(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status string
+	err = pgdb.pool.QueryRow(ctx, `SELECT statusorder FROM public.orders WHERE ordernumber=$1`, number).Scan(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != models.InvalidOrder {
+		t.Errorf("order status = %s, want %s", status, models.InvalidOrder)
+	}
+	history, err := pgdb.GetOrderStatusHistory(ctx, number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.From != models.ProcessingOrder || last.To != models.InvalidOrder || last.Reason != "account closed" {
+		t.Errorf("last status change = %+v, want PROCESSING -> INVALID with reason", last)
+	}
+}
@@ -2,28 +2,41 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/MlDenis/internal/amount"
 	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/internal/gofermart/rules"
+	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5"
 )
 
-// Получение баланса пользователя
+// Получение баланса пользователя, считаем по журналу
 func (pgdb *PostgresDB) GetBalanceDB(ctx context.Context, userlogin string) (*models.ResponseBalance, error) {
-	tx, err := pgdb.pool.Begin(ctx)
+	ResponseBalance := &models.ResponseBalance{}
+	row := pgdb.pool.QueryRow(ctx,
+		`SELECT COALESCE(SUM(l.amount), 0)::bigint,
+			COALESCE(-SUM(l.amount) FILTER (WHERE l.entrytype IN ('withdrawal', 'reversal')), 0)::bigint,
+			b.sumheld
+		FROM public.balance b
+		LEFT JOIN public.ledgeraccounts a ON a.userlogin = b.userlogin
+		LEFT JOIN public.ledger l ON l.accountid = a.id AND l.account = $2
+		WHERE b.userlogin=$1
+		GROUP BY b.userlogin, b.sumheld`,
+		userlogin, models.LedgerUserAccount,
+	)
+	err := row.Scan(&ResponseBalance.AccrualSum, &ResponseBalance.WithdrawSum, &ResponseBalance.Held)
 	if err != nil {
-
 		return nil, err
 	}
-
-	ResponseBalance := &models.ResponseBalance{}
-	row := pgdb.pool.QueryRow(ctx, `SELECT sumaccrual,sumwithdraw FROM public.balance WHERE userlogin=$1`, userlogin)
-	err = row.Scan(&ResponseBalance.AccrualSum, &ResponseBalance.WithdrawSum)
+	ResponseBalance.Available = ResponseBalance.AccrualSum - ResponseBalance.Held
+	ResponseBalance.ExpiringSoon, ResponseBalance.NextExpiry, err = pgdb.expiringSoon(ctx, userlogin)
 	if err != nil {
-
-		tx.Rollback(ctx)
 		return nil, err
 	}
 
-	return ResponseBalance, tx.Commit(ctx)
+	return ResponseBalance, nil
 }
 
 // При авторизации сразу заполняем баланс пользователя по нулям
@@ -45,21 +58,38 @@ func (pgdb *PostgresDB) AuthorizationBalance(ctx context.Context, userlogin stri
 	return tx.Commit(ctx)
 }
 
-// Меняем баланс при списании
-func (pgdb *PostgresDB) EditBalanceWithdraw(ctx context.Context, userlogin string, sumwithdraw int64) error {
-
+// Списание баллов: заказ на списание и проводка пишутся в одной транзакции,
+// строка баланса блокируется, поэтому параллельные списания не уведут баланс в минус
+func (pgdb *PostgresDB) WithdrawBalanceDB(ctx context.Context, userlogin string, ordernumber string, sumwithdraw amount.Amount) error {
 	tx, err := pgdb.pool.Begin(ctx)
 	if err != nil {
 
 		return err
 	}
 
-	_, err = tx.Exec(ctx, `INSERT INTO public.balance (userlogin, sumaccrual, sumwithdraw)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (userlogin) DO UPDATE
-		SET sumaccrual = public.balance.sumaccrual - EXCLUDED.sumaccrual,
-		sumwithdraw = public.balance.sumwithdraw + EXCLUDED.sumwithdraw `,
-		userlogin, sumwithdraw, sumwithdraw)
+	//доступно к списанию все, кроме зарезервированного
+	var current amount.Amount
+	row := tx.QueryRow(ctx, `SELECT sumaccrual - sumheld FROM public.balance WHERE userlogin=$1 FOR UPDATE`, userlogin)
+	err = row.Scan(&current)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pkg.UserNotExist
+		}
+		return err
+	}
+	err = pgdb.checkWithdrawRules(ctx, tx, userlogin, sumwithdraw)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	if current < sumwithdraw {
+		tx.Rollback(ctx)
+		return pkg.InsufficientFunds
+	}
+	err = pgdb.withdraw(ctx, tx, userlogin, ordernumber, sumwithdraw)
 	if err != nil {
 
 		tx.Rollback(ctx)
@@ -69,24 +99,116 @@ func (pgdb *PostgresDB) EditBalanceWithdraw(ctx context.Context, userlogin strin
 	return tx.Commit(ctx)
 }
 
-// Меняем баланс при начислении
-func (pgdb *PostgresDB) EditBalanceAccrual(ctx context.Context, userlogin string, accrual int64) error {
+// проверяем лимиты списаний. В использованные лимиты входят списания и действующие резервы
+// за текущие сутки и месяц. Вызывается после блокировки строки баланса, поэтому
+// параллельные списания не могут вместе превысить лимит. Нарушение возвращается как *rules.Violation
+func (pgdb *PostgresDB) checkWithdrawRules(ctx context.Context, tx pgx.Tx, userlogin string, sum amount.Amount) error {
+	usage := rules.Usage{}
+	if pgdb.withdrawLimits.NeedUsage() {
+		now := time.Now()
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		row := tx.QueryRow(ctx,
+			`SELECT
+				COALESCE((SELECT -SUM(amount) FILTER (WHERE createdat >= $5) FROM public.ledger
+					WHERE accountid=(SELECT id FROM public.ledgeraccounts WHERE userlogin=$1) AND account=$2 AND entrytype=$3 AND createdat >= $6), 0)::bigint
+				+ COALESCE((SELECT SUM(amount) FILTER (WHERE createdat >= $5) FROM public.holds
+					WHERE userlogin=$1 AND status=$4 AND createdat >= $6), 0)::bigint,
+				COALESCE((SELECT -SUM(amount) FROM public.ledger
+					WHERE accountid=(SELECT id FROM public.ledgeraccounts WHERE userlogin=$1) AND account=$2 AND entrytype=$3 AND createdat >= $6), 0)::bigint
+				+ COALESCE((SELECT SUM(amount) FROM public.holds
+					WHERE userlogin=$1 AND status=$4 AND createdat >= $6), 0)::bigint`,
+			userlogin, models.LedgerUserAccount, models.EntryWithdrawal, models.HoldHeld, dayStart, monthStart,
+		)
+		err := row.Scan(&usage.Daily, &usage.Monthly)
+		if err != nil {
+			return err
+		}
+	}
+	if violation := pgdb.withdrawLimits.Evaluate(sum, usage); violation != nil {
+		return violation
+	}
+	return nil
+}
 
+// записываем заказ на списание и проводку, строка баланса должна быть уже заблокирована
+func (pgdb *PostgresDB) withdraw(ctx context.Context, tx pgx.Tx, userlogin string, ordernumber string, sumwithdraw amount.Amount) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO public.orders (ordernumber,userlogin,orderdate,statusorder,withdraw) VALUES ($1, $2,$3, $4, $5)`,
+		ordernumber, userlogin, time.Now(), models.WithdrawEnd, sumwithdraw,
+	)
+	if err != nil {
+		return err
+	}
+	return pgdb.postEntry(ctx, tx, models.LedgerEntry{
+		UserLogin:   userlogin,
+		EntryType:   models.EntryWithdrawal,
+		Amount:      -sumwithdraw,
+		OrderNumber: ordernumber,
+	})
+}
+
+// Возврат списания: баллы возвращаются на счет, запись о списании остается с отметкой о возврате
+func (pgdb *PostgresDB) ReverseWithdrawal(ctx context.Context, ordernumber string, reason string) error {
 	tx, err := pgdb.pool.Begin(ctx)
 	if err != nil {
 
 		return err
 	}
 
+	var userlogin string
+	var withdraw amount.Amount
+	var reversedAt *time.Time
+	row := tx.QueryRow(ctx,
+		`SELECT userlogin, withdraw, reversedat FROM public.orders WHERE ordernumber=$1 AND statusorder=$2 FOR UPDATE`,
+		ordernumber, models.WithdrawEnd,
+	)
+	err = row.Scan(&userlogin, &withdraw, &reversedAt)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pkg.WithdrawalNotFound
+		}
+		return err
+	}
+	if reversedAt != nil {
+		tx.Rollback(ctx)
+		return pkg.WithdrawalAlreadyReversed
+	}
+	now := time.Now()
 	_, err = tx.Exec(ctx,
-		`UPDATE public.balance set sumaccrual =sumaccrual+ $1 WHERE userlogin=$2`,
-		accrual, userlogin,
+		`UPDATE public.orders SET reversedat = $1, reversereason = $2 WHERE ordernumber=$3`,
+		now, reason, ordernumber,
 	)
 	if err != nil {
 
 		tx.Rollback(ctx)
 		return err
 	}
+	err = pgdb.postEntry(ctx, tx, models.LedgerEntry{
+		UserLogin:   userlogin,
+		EntryType:   models.EntryReversal,
+		Amount:      withdraw,
+		OrderNumber: ordernumber,
+		Reason:      reason,
+		CreatedAt:   now,
+	})
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
 
 	return tx.Commit(ctx)
 }
+
+// Ручная корректировка баланса администратором, баланс не может стать отрицательным
+func (pgdb *PostgresDB) AdjustBalance(ctx context.Context, userlogin string, sum amount.Amount, reason string) error {
+	return pgdb.postEntryTx(ctx, models.LedgerEntry{
+		UserLogin: userlogin,
+		EntryType: models.EntryAdjustment,
+		Amount:    sum,
+		Reason:    reason,
+	})
+}
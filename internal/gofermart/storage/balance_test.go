@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/pkg"
+)
+
+// параллельные списания с одного баланса: проходят ровно floor(balance/sum),
+// остальные получают pkg.InsufficientFunds, баланс не уходит в минус
+func TestWithdrawBalanceDBConcurrent(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	login := testLogin()
+	balance := amount.FromInt(100)
+	sum := amount.FromInt(7)
+	createTestUser(t, pgdb, login, balance)
+
+	const workers = 30
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	start := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- pgdb.WithdrawBalanceDB(ctx, login, testOrderNumber(), sum)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded, insufficient := 0, 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, pkg.InsufficientFunds):
+			insufficient++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	want := int(balance / sum)
+	if succeeded != want {
+		t.Errorf("succeeded withdrawals = %d, want %d", succeeded, want)
+	}
+	if insufficient != workers-want {
+		t.Errorf("insufficient funds = %d, want %d", insufficient, workers-want)
+	}
+
+	var sumAccrual amount.Amount
+	err := pgdb.pool.QueryRow(ctx, `SELECT sumaccrual FROM public.balance WHERE userlogin=$1`, login).Scan(&sumAccrual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sumAccrual < 0 {
+		t.Errorf("sumaccrual = %s, must not be negative", sumAccrual)
+	}
+	if wantLeft := balance - amount.Amount(succeeded)*sum; sumAccrual != wantLeft {
+		t.Errorf("sumaccrual = %s, want %s", sumAccrual, wantLeft)
+	}
+}
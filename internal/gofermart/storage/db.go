@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/rules"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -17,6 +20,16 @@ import (
 
 type PostgresDB struct {
 	pool *pgxpool.Pool
+	//срок действия начисленных баллов, 0 - бессрочно
+	pointsTTL time.Duration
+	//за сколько до сгорания показывать баллы в expiring_soon
+	expiryNotice time.Duration
+	//сколько баллов пользователь может перевести другим за сутки, 0 - без ограничения
+	transferDailyLimit amount.Amount
+	//через сколько неподтвержденный резерв снимается автоматически
+	holdTimeout time.Duration
+	//ограничения на списания
+	withdrawLimits rules.Limits
 }
 
 // инизиацлизация бд
@@ -42,6 +55,27 @@ func InitDB(configDB string, migratePath string, log *zap.Logger) (*PostgresDB,
 	return nil, fmt.Errorf("failed to create a connection pool: %w", err)
 }
 
+// политика сгорания баллов, действует для новых начислений
+func (pgdb *PostgresDB) SetExpiryPolicy(pointsTTL, expiryNotice time.Duration) {
+	pgdb.pointsTTL = pointsTTL
+	pgdb.expiryNotice = expiryNotice
+}
+
+// лимит переводов между пользователями за календарные сутки
+func (pgdb *PostgresDB) SetTransferDailyLimit(limit amount.Amount) {
+	pgdb.transferDailyLimit = limit
+}
+
+// время жизни резерва баллов
+func (pgdb *PostgresDB) SetHoldTimeout(timeout time.Duration) {
+	pgdb.holdTimeout = timeout
+}
+
+// лимиты списаний, проверяются в транзакции списания и резервирования
+func (pgdb *PostgresDB) SetWithdrawLimits(limits rules.Limits) {
+	pgdb.withdrawLimits = limits
+}
+
 // функция чтобы закрыть соедининение
 func (pgdb *PostgresDB) Close() {
 	pgdb.pool.Close()
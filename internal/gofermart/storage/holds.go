@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// случайный публичный идентификатор резерва
+func newHoldID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// резервируем баллы под оплату заказа, резерв уменьшает доступный баланс
+func (pgdb *PostgresDB) CreateHold(ctx context.Context, userlogin string, ordernumber string, sum amount.Amount) (*models.Hold, error) {
+	holdID, err := newHoldID()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	var available amount.Amount
+	row := tx.QueryRow(ctx, `SELECT sumaccrual - sumheld FROM public.balance WHERE userlogin=$1 FOR UPDATE`, userlogin)
+	err = row.Scan(&available)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pkg.UserNotExist
+		}
+		return nil, err
+	}
+	//номер уже занят заказом: списание по нему при подтверждении резерва все равно не пройдет
+	var owner string
+	err = tx.QueryRow(ctx, `SELECT userlogin FROM public.orders WHERE ordernumber=$1`, ordernumber).Scan(&owner)
+	if err == nil {
+		tx.Rollback(ctx)
+		if owner == userlogin {
+			return nil, pkg.OrderAlreadyUploaded
+		}
+		return nil, pkg.OrderUploadedByAnotherUser
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	err = pgdb.checkWithdrawRules(ctx, tx, userlogin, sum)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	if available < sum {
+		tx.Rollback(ctx)
+		return nil, pkg.InsufficientFunds
+	}
+	now := time.Now()
+	hold := &models.Hold{
+		HoldID:      holdID,
+		UserLogin:   userlogin,
+		OrderNumber: ordernumber,
+		Sum:         sum,
+		Status:      models.HoldHeld,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(pgdb.holdTimeout),
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO public.holds (holdid,userlogin,ordernumber,amount,status,createdat,expiresat) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		hold.HoldID, hold.UserLogin, hold.OrderNumber, hold.Sum, hold.Status, hold.CreatedAt, hold.ExpiresAt,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.balance SET sumheld = sumheld + $1 WHERE userlogin=$2`, sum, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	return hold, tx.Commit(ctx)
+}
+
+// блокируем действующий резерв пользователя и снимаем его с баланса,
+// дальше вызывающий либо списывает баллы, либо просто закрывает резерв
+func lockActiveHold(ctx context.Context, tx pgx.Tx, userlogin, holdID string) (*models.Hold, error) {
+	hold := &models.Hold{HoldID: holdID, UserLogin: userlogin}
+	row := tx.QueryRow(ctx,
+		`SELECT ordernumber, amount, status, createdat, expiresat FROM public.holds WHERE holdid=$1 AND userlogin=$2`,
+		holdID, userlogin,
+	)
+	err := row.Scan(&hold.OrderNumber, &hold.Sum, &hold.Status, &hold.CreatedAt, &hold.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pkg.HoldNotFound
+		}
+		return nil, err
+	}
+	// сначала строка баланса, потом резерв - в том же порядке, что и при создании
+	_, err = tx.Exec(ctx, `SELECT 1 FROM public.balance WHERE userlogin=$1 FOR UPDATE`, userlogin)
+	if err != nil {
+		return nil, err
+	}
+	// срок сравниваем в запросе, как в ReleaseExpiredHolds: expiresat хранится без зоны
+	// по часам сервера, а при чтении pgx считает его UTC
+	var active bool
+	row = tx.QueryRow(ctx, `SELECT status, expiresat > $2 FROM public.holds WHERE holdid=$1 FOR UPDATE`, holdID, time.Now())
+	err = row.Scan(&hold.Status, &active)
+	if err != nil {
+		return nil, err
+	}
+	if hold.Status != models.HoldHeld || !active {
+		return nil, pkg.HoldNotActive
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.balance SET sumheld = sumheld - $1 WHERE userlogin=$2`, hold.Sum, userlogin)
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+func closeHold(ctx context.Context, tx pgx.Tx, holdID, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE public.holds SET status = $1, closedat = $2 WHERE holdid=$3`, status, time.Now(), holdID)
+	return err
+}
+
+// списываем зарезервированные баллы, как обычное списание по заказу
+func (pgdb *PostgresDB) CaptureHold(ctx context.Context, userlogin, holdID string) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	hold, err := lockActiveHold(ctx, tx, userlogin, holdID)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	err = pgdb.withdraw(ctx, tx, userlogin, hold.OrderNumber, hold.Sum)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	err = closeHold(ctx, tx, holdID, models.HoldCaptured)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// снимаем резерв, баллы снова доступны
+func (pgdb *PostgresDB) ReleaseHold(ctx context.Context, userlogin, holdID string) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	_, err = lockActiveHold(ctx, tx, userlogin, holdID)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	err = closeHold(ctx, tx, holdID, models.HoldReleased)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// снимаем просроченные резервы
+func (pgdb *PostgresDB) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return 0, err
+	}
+
+	now := time.Now()
+	// блокируем строки баланса владельцев в порядке логинов, как при переводах
+	_, err = tx.Exec(ctx,
+		`SELECT 1 FROM public.balance WHERE userlogin IN (
+			SELECT userlogin FROM public.holds WHERE status = $1 AND expiresat <= $2
+		) ORDER BY userlogin FOR UPDATE`,
+		models.HoldHeld, now,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx,
+		`WITH expired AS (
+			UPDATE public.holds SET status = $1, closedat = $3
+			WHERE status = $2 AND expiresat <= $3
+			RETURNING userlogin, amount
+		)
+		UPDATE public.balance b SET sumheld = b.sumheld - e.total
+		FROM (SELECT userlogin, SUM(amount) AS total FROM expired GROUP BY userlogin) e
+		WHERE b.userlogin = e.userlogin`,
+		models.HoldExpired, models.HoldHeld, now,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), tx.Commit(ctx)
+}
+
+// периодически снимаем просроченные резервы
+func (pgdb *PostgresDB) RunHoldExpiry(ctx context.Context, interval time.Duration, log *zap.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			released, err := pgdb.ReleaseExpiredHolds(ctx)
+			if err != nil {
+				log.Error("error in release expired holds: ", zap.Error(err))
+				continue
+			}
+			if released > 0 {
+				log.Info("expired holds released", zap.Int("users", released))
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+)
+
+// срок резерва проверяется по часам бд: действующий резерв снимается, просроченный - нет,
+// в какой бы зоне ни работал сервер
+func TestLockActiveHoldExpiry(t *testing.T) {
+	pgdb := newTestDB(t)
+	pgdb.SetHoldTimeout(time.Hour)
+	ctx := context.Background()
+	login := testLogin()
+	createTestUser(t, pgdb, login, amount.FromInt(100))
+
+	active, err := pgdb.CreateHold(ctx, login, testOrderNumber(), amount.FromInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pgdb.ReleaseHold(ctx, login, active.HoldID)
+	if err != nil {
+		t.Errorf("release active hold: %v", err)
+	}
+
+	expired, err := pgdb.CreateHold(ctx, login, testOrderNumber(), amount.FromInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pgdb.pool.Exec(ctx, `UPDATE public.holds SET expiresat = $1 WHERE holdid=$2`, time.Now().Add(-time.Second), expired.HoldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pgdb.CaptureHold(ctx, login, expired.HoldID)
+	if !errors.Is(err, pkg.HoldNotActive) {
+		t.Errorf("capture expired hold: error = %v, want %v", err, pkg.HoldNotActive)
+	}
+}
+
+// резерв на номер уже загруженного заказа не создается: подтвердить его все равно
+// было бы нельзя, а баллы висели бы в резерве до истечения срока
+func TestCreateHoldOrderExists(t *testing.T) {
+	pgdb := newTestDB(t)
+	pgdb.SetHoldTimeout(time.Hour)
+	ctx := context.Background()
+	owner := testLogin()
+	other := testLogin()
+	number := testOrderNumber()
+	createTestUser(t, pgdb, owner, amount.FromInt(100))
+	createTestUser(t, pgdb, other, amount.FromInt(100))
+	err := pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: owner, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pgdb.CreateHold(ctx, owner, number, amount.FromInt(10))
+	if !errors.Is(err, pkg.OrderAlreadyUploaded) {
+		t.Errorf("hold on own order: error = %v, want %v", err, pkg.OrderAlreadyUploaded)
+	}
+	_, err = pgdb.CreateHold(ctx, other, number, amount.FromInt(10))
+	if !errors.Is(err, pkg.OrderUploadedByAnotherUser) {
+		t.Errorf("hold on another user's order: error = %v, want %v", err, pkg.OrderUploadedByAnotherUser)
+	}
+	balance, err := pgdb.GetBalanceDB(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Available != amount.FromInt(100) {
+		t.Errorf("available = %s, want 100", balance.Available)
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// занимаем ключ идемпотентности. Если ключа нет, он старше expiredBefore или это брошенный
+// незавершенный запрос с тем же отпечатком, начатый раньше abandonedBefore, создаем запись
+// и возвращаем created=true, иначе возвращаем сохраненную запись
+func (pgdb *PostgresDB) BeginIdempotentRequest(ctx context.Context, userlogin, key, fingerprint string, expiredBefore, abandonedBefore time.Time) (*models.IdempotencyRecord, bool, error) {
+	var id int64
+	row := pgdb.pool.QueryRow(ctx,
+		`INSERT INTO public.idempotencykeys (userlogin,idemkey,fingerprint,createdat) VALUES ($1, $2, $3, now())
+		ON CONFLICT (userlogin, idemkey) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, createdat = EXCLUDED.createdat,
+		completed = false, statuscode = NULL, contenttype = NULL, body = NULL
+		WHERE public.idempotencykeys.createdat < $4
+			OR (NOT public.idempotencykeys.completed AND public.idempotencykeys.createdat < $5
+				AND public.idempotencykeys.fingerprint = EXCLUDED.fingerprint)
+		RETURNING id`,
+		userlogin, key, fingerprint, expiredBefore, abandonedBefore,
+	)
+	err := row.Scan(&id)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	record := &models.IdempotencyRecord{}
+	var statusCode *int
+	var contentType *string
+	row = pgdb.pool.QueryRow(ctx,
+		`SELECT fingerprint, completed, statuscode, contenttype, body, createdat FROM public.idempotencykeys WHERE userlogin=$1 AND idemkey=$2`,
+		userlogin, key,
+	)
+	err = row.Scan(&record.Fingerprint, &record.Completed, &statusCode, &contentType, &record.Body, &record.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	if statusCode != nil {
+		record.StatusCode = *statusCode
+	}
+	if contentType != nil {
+		record.ContentType = *contentType
+	}
+	return record, false, nil
+}
+
+// сохраняем ответ, который отдадим на повтор запроса с тем же ключом
+func (pgdb *PostgresDB) CompleteIdempotentRequest(ctx context.Context, userlogin, key string, statusCode int, contentType string, body []byte) error {
+	_, err := pgdb.pool.Exec(ctx,
+		`UPDATE public.idempotencykeys SET completed = true, statuscode = $1, contenttype = $2, body = $3 WHERE userlogin=$4 AND idemkey=$5`,
+		statusCode, contentType, body, userlogin, key,
+	)
+	return err
+}
+
+// освобождаем ключ, если запрос не удалось обработать и его можно повторить
+func (pgdb *PostgresDB) DeleteIdempotencyKey(ctx context.Context, userlogin, key string) error {
+	_, err := pgdb.pool.Exec(ctx, `DELETE FROM public.idempotencykeys WHERE userlogin=$1 AND idemkey=$2`, userlogin, key)
+	return err
+}
+
+// удаляем ключи старше окна идемпотентности
+func (pgdb *PostgresDB) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) error {
+	_, err := pgdb.pool.Exec(ctx, `DELETE FROM public.idempotencykeys WHERE createdat < $1`, before)
+	return err
+}
+
+// периодически чистим устаревшие ключи идемпотентности
+func (pgdb *PostgresDB) RunIdempotencyEviction(ctx context.Context, interval, window time.Duration, log *zap.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			err := pgdb.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-window))
+			if err != nil {
+				log.Error("error in delete expired idempotency keys: ", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// системный счет, на который уходит вторая половина проводки
+func systemAccount(entryType string) string {
+	switch entryType {
+	case models.EntryReversal:
+		return models.EntryWithdrawal
+	case models.EntryTransferIn, models.EntryTransferOut:
+		return models.LedgerTransferAccount
+	}
+	return entryType
+}
+
+// записываем операцию в журнал двумя проводками и меняем материализованный баланс
+// и партии баллов в той же транзакции. Баланс не может стать отрицательным,
+// а списания не могут затронуть зарезервированные баллы (кроме сгорания)
+func (pgdb *PostgresDB) postEntry(ctx context.Context, tx pgx.Tx, entry models.LedgerEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	var withdraw amount.Amount
+	if entry.EntryType == models.EntryWithdrawal || entry.EntryType == models.EntryReversal {
+		withdraw = -entry.Amount
+	}
+	respectHolds := entry.Amount < 0 && entry.EntryType != models.EntryExpiry
+	tag, err := tx.Exec(ctx,
+		`UPDATE public.balance SET sumaccrual = sumaccrual + $1, sumwithdraw = sumwithdraw + $2
+		WHERE userlogin=$3 AND sumaccrual + $1 >= CASE WHEN $4 THEN sumheld ELSE 0 END`,
+		entry.Amount, withdraw, entry.UserLogin, respectHolds,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM public.balance WHERE userlogin=$1)`, entry.UserLogin).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return pkg.UserNotExist
+		}
+		return pkg.InsufficientFunds
+	}
+
+	accountID, err := ledgerAccountID(ctx, tx, entry.UserLogin)
+	if err != nil {
+		return err
+	}
+	var orderNumber *string
+	if entry.OrderNumber != "" {
+		orderNumber = &entry.OrderNumber
+	}
+	var reason *string
+	if entry.Reason != "" {
+		reason = &entry.Reason
+	}
+	//вторая сторона перевода тоже записывается счетом, ее строка баланса уже заблокирована в TransferBalance
+	var counterpartyID *int64
+	if entry.Counterparty != "" {
+		id, err := ledgerAccountID(ctx, tx, entry.Counterparty)
+		if err != nil {
+			return err
+		}
+		counterpartyID = &id
+	}
+	_, err = tx.Exec(ctx,
+		`WITH t AS (SELECT nextval('public.ledger_txid_seq') AS txid)
+		INSERT INTO public.ledger (txid,accountid,account,entrytype,amount,ordernumber,reason,counterpartyid,createdat)
+		SELECT t.txid, $1::bigint, $2::text, $4::text, $5::bigint, $6::text, $7::text, $9::bigint, $8::timestamp FROM t
+		UNION ALL
+		SELECT t.txid, $1::bigint, $3::text, $4::text, -$5::bigint, $6::text, $7::text, $9::bigint, $8::timestamp FROM t`,
+		accountID, models.LedgerUserAccount, systemAccount(entry.EntryType), entry.EntryType,
+		entry.Amount, orderNumber, reason, entry.CreatedAt, counterpartyID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return pgdb.updateLots(ctx, tx, entry)
+}
+
+// счет пользователя в журнале, создается при первой проводке.
+// Вызывается после блокировки строки баланса, поэтому параллельное создание счета
+// для одного логина уже зафиксировано и видно следующему запросу
+func ledgerAccountID(ctx context.Context, tx pgx.Tx, userlogin string) (int64, error) {
+	var accountID int64
+	err := tx.QueryRow(ctx, `SELECT id FROM public.ledgeraccounts WHERE userlogin=$1`, userlogin).Scan(&accountID)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return accountID, err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO public.ledgeraccounts (userlogin) VALUES ($1) ON CONFLICT (userlogin) DO NOTHING`, userlogin)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow(ctx, `SELECT id FROM public.ledgeraccounts WHERE userlogin=$1`, userlogin).Scan(&accountID)
+	return accountID, err
+}
+
+// проводим одну операцию в отдельной транзакции
+func (pgdb *PostgresDB) postEntryTx(ctx context.Context, entry models.LedgerEntry) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	err = pgdb.postEntry(ctx, tx, entry)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// выписка по счету пользователя от старых операций к новым,
+// остаток считается по всему журналу, а не только по выбранному периоду
+func (pgdb *PostgresDB) GetBalanceHistory(ctx context.Context, userlogin string, filter models.BalanceHistoryFilter) ([]models.BalanceHistoryEntry, error) {
+	entries := []models.BalanceHistoryEntry{}
+	rows, err := pgdb.pool.Query(ctx,
+		`SELECT id, entrytype, amount, balance, ordernumber, reason, counterparty, createdat FROM (
+			SELECT l.id, l.entrytype, l.amount, (SUM(l.amount) OVER (ORDER BY l.createdat, l.id))::bigint AS balance,
+				l.ordernumber, l.reason, c.userlogin AS counterparty, l.createdat
+			FROM public.ledger l LEFT JOIN public.ledgeraccounts c ON c.id = l.counterpartyid
+			WHERE l.accountid=(SELECT id FROM public.ledgeraccounts WHERE userlogin=$1) AND l.account=$2
+		) h
+		WHERE ($3::timestamp IS NULL OR createdat >= $3::timestamp)
+			AND ($4::timestamp IS NULL OR createdat < $4::timestamp)
+			AND ($5::timestamp IS NULL OR (createdat, id) > ($5::timestamp, $6::bigint))
+		ORDER BY createdat, id
+		LIMIT $7`,
+		userlogin, models.LedgerUserAccount, filter.From, filter.To, filter.AfterTime, filter.AfterID, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := models.BalanceHistoryEntry{}
+		var orderNumber *string
+		var reason, counterparty *string
+		err := rows.Scan(&entry.ID, &entry.EntryType, &entry.Amount, &entry.Balance, &orderNumber, &reason, &counterparty, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if orderNumber != nil {
+			entry.OrderNumber = *orderNumber
+		}
+		if reason != nil {
+			entry.Reason = *reason
+		}
+		if counterparty != nil {
+			entry.Counterparty = *counterparty
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// сверка: материализованный баланс должен совпадать с суммой по журналу,
+// а каждая операция журнала - давать в сумме ноль
+func (pgdb *PostgresDB) ReconcileLedger(ctx context.Context) ([]models.LedgerMismatch, error) {
+	mismatches := []models.LedgerMismatch{}
+	rows, err := pgdb.pool.Query(ctx,
+		`SELECT b.userlogin, b.sumaccrual, b.sumwithdraw,
+			COALESCE(SUM(l.amount), 0)::bigint,
+			COALESCE(-SUM(l.amount) FILTER (WHERE l.entrytype IN ('withdrawal', 'reversal')), 0)::bigint
+		FROM public.balance b
+		LEFT JOIN public.ledgeraccounts a ON a.userlogin = b.userlogin
+		LEFT JOIN public.ledger l ON l.accountid = a.id AND l.account = $1
+		GROUP BY b.userlogin, b.sumaccrual, b.sumwithdraw
+		HAVING b.sumaccrual <> COALESCE(SUM(l.amount), 0)
+			OR b.sumwithdraw <> COALESCE(-SUM(l.amount) FILTER (WHERE l.entrytype IN ('withdrawal', 'reversal')), 0)`,
+		models.LedgerUserAccount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		mismatch := models.LedgerMismatch{}
+		err := rows.Scan(&mismatch.UserLogin, &mismatch.BalanceSum, &mismatch.BalanceWithdraw, &mismatch.LedgerSum, &mismatch.LedgerWithdraw)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = pgdb.pool.Query(ctx, `SELECT txid, SUM(amount)::bigint FROM public.ledger GROUP BY txid HAVING SUM(amount) <> 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		mismatch := models.LedgerMismatch{}
+		err := rows.Scan(&mismatch.TxID, &mismatch.LedgerSum)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	return mismatches, rows.Err()
+}
+
+// периодически сверяем баланс с журналом и пишем расхождения в лог
+func (pgdb *PostgresDB) RunReconcile(ctx context.Context, interval time.Duration, log *zap.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			mismatches, err := pgdb.ReconcileLedger(ctx)
+			if err != nil {
+				log.Error("error in reconcile ledger: ", zap.Error(err))
+				continue
+			}
+			for _, mismatch := range mismatches {
+				log.Error("ledger mismatch",
+					zap.String("login", mismatch.UserLogin),
+					zap.Int64("txid", mismatch.TxID),
+					zap.Stringer("balance_sum", mismatch.BalanceSum),
+					zap.Stringer("ledger_sum", mismatch.LedgerSum),
+					zap.Stringer("balance_withdraw", mismatch.BalanceWithdraw),
+					zap.Stringer("ledger_withdraw", mismatch.LedgerWithdraw),
+				)
+			}
+		}
+	}
+}
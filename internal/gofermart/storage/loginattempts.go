@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// время, до которого заблокирован вход по ключу (логин или адрес)
+func (pgdb *PostgresDB) GetLoginLock(ctx context.Context, key string) (time.Time, error) {
+	var lockedUntil *time.Time
+	row := pgdb.pool.QueryRow(ctx, `SELECT lockeduntil FROM public.loginattempts WHERE attemptkey=$1`, key)
+	err := row.Scan(&lockedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *lockedUntil, nil
+}
+
+// учитываем неудачную попытку входа и возвращаем число неудач подряд,
+// неудачи раньше resetBefore не учитываем
+func (pgdb *PostgresDB) RegisterLoginFailure(ctx context.Context, key string, resetBefore time.Time) (int, error) {
+	var failures int
+	row := pgdb.pool.QueryRow(ctx,
+		`INSERT INTO public.loginattempts (attemptkey,failures,lastfailure) VALUES ($1, 1, now())
+		ON CONFLICT (attemptkey) DO UPDATE
+		SET failures = CASE WHEN public.loginattempts.lastfailure < $2 THEN 1 ELSE public.loginattempts.failures + 1 END,
+		lastfailure = now()
+		RETURNING failures`,
+		key, resetBefore,
+	)
+	err := row.Scan(&failures)
+	return failures, err
+}
+
+// блокируем вход по ключу
+func (pgdb *PostgresDB) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := pgdb.pool.Exec(ctx, `UPDATE public.loginattempts SET lockeduntil = $1 WHERE attemptkey=$2`, until, key)
+	return err
+}
+
+// сбрасываем счетчик после успешного входа
+func (pgdb *PostgresDB) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := pgdb.pool.Exec(ctx, `DELETE FROM public.loginattempts WHERE attemptkey=$1`, key)
+	return err
+}
+
+// удаляем счетчики без свежих неудач и без действующей блокировки
+func (pgdb *PostgresDB) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error {
+	_, err := pgdb.pool.Exec(ctx,
+		`DELETE FROM public.loginattempts WHERE lastfailure < $1 AND (lockeduntil IS NULL OR lockeduntil < now())`,
+		before,
+	)
+	return err
+}
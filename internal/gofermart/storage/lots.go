@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// поступление создает новую партию, расход списывает партии от старых к новым по времени
+// поступления (FIFO), независимо от срока сгорания. сгорание уменьшает конкретную партию само, см. expireLot,
+// а перевод переносит партии отправителя получателю, см. transferLots.
+// При списании по заказу запоминаем, из каких партий оно сделано, и возврат списания
+// восстанавливает эти партии (restoreLots), иначе возврат продлевал бы срок баллов
+func (pgdb *PostgresDB) updateLots(ctx context.Context, tx pgx.Tx, entry models.LedgerEntry) error {
+	switch entry.EntryType {
+	case models.EntryExpiry, models.EntryTransferIn, models.EntryTransferOut:
+		return nil
+	}
+	if entry.EntryType == models.EntryReversal && entry.Amount > 0 {
+		return pgdb.restoreLots(ctx, tx, entry)
+	}
+	if entry.Amount > 0 {
+		return pgdb.addLot(ctx, tx, entry.UserLogin, entry.Amount, entry.CreatedAt)
+	}
+	var withdrawOrder *string
+	if entry.EntryType == models.EntryWithdrawal && entry.OrderNumber != "" {
+		withdrawOrder = &entry.OrderNumber
+	}
+	// строка баланса уже заблокирована в postEntry, поэтому партии пользователя никто не меняет параллельно
+	_, err := tx.Exec(ctx,
+		`WITH ordered AS (
+			SELECT id, remaining, SUM(remaining) OVER (ORDER BY createdat, id) AS cum
+			FROM public.accruallots WHERE userlogin=$1 AND remaining > 0
+		), taken AS (
+			UPDATE public.accruallots l
+			SET remaining = l.remaining - LEAST(o.remaining, $2 - (o.cum - o.remaining))
+			FROM ordered o
+			WHERE l.id = o.id AND o.cum - o.remaining < $2
+			RETURNING o.remaining - l.remaining AS amount, l.createdat, l.expiresat
+		)
+		INSERT INTO public.withdrawnlots (ordernumber,amount,createdat,expiresat)
+		SELECT $3::text, amount, createdat, expiresat FROM taken WHERE $3::text IS NOT NULL AND amount > 0`,
+		entry.UserLogin, -entry.Amount, withdrawOrder,
+	)
+	return err
+}
+
+// возврат списания: партии, из которых оно было сделано, возвращаются с прежними временем
+// поступления и сроком сгорания. Уже истекшие сгорят при следующем запуске сгорания
+func (pgdb *PostgresDB) restoreLots(ctx context.Context, tx pgx.Tx, entry models.LedgerEntry) error {
+	var restored amount.Amount
+	row := tx.QueryRow(ctx,
+		`WITH taken AS (
+			DELETE FROM public.withdrawnlots WHERE ordernumber=$2
+			RETURNING amount, createdat, expiresat
+		), restored AS (
+			INSERT INTO public.accruallots (userlogin,amount,remaining,createdat,expiresat)
+			SELECT $1, amount, amount, createdat, expiresat FROM taken
+			RETURNING amount
+		)
+		SELECT COALESCE(SUM(amount), 0)::bigint FROM restored`,
+		entry.UserLogin, entry.OrderNumber,
+	)
+	err := row.Scan(&restored)
+	if err != nil {
+		return err
+	}
+	// списания до учета партий восстановить не из чего, остаток возвращаем новой партией
+	if restored < entry.Amount {
+		return pgdb.addLot(ctx, tx, entry.UserLogin, entry.Amount-restored, entry.CreatedAt)
+	}
+	return nil
+}
+
+// новая партия со сроком действия от момента поступления
+func (pgdb *PostgresDB) addLot(ctx context.Context, tx pgx.Tx, userlogin string, sum amount.Amount, createdAt time.Time) error {
+	var expiresAt *time.Time
+	if pgdb.pointsTTL > 0 {
+		t := createdAt.Add(pgdb.pointsTTL)
+		expiresAt = &t
+	}
+	_, err := tx.Exec(ctx,
+		`INSERT INTO public.accruallots (userlogin,amount,remaining,createdat,expiresat) VALUES ($1, $2, $2, $3, $4)`,
+		userlogin, sum, createdAt, expiresAt,
+	)
+	return err
+}
+
+// при переводе списываем партии отправителя по FIFO и создаем получателю партии
+// с теми же временем поступления и сроком сгорания, иначе перевод продлевал бы срок баллов.
+// строки баланса обеих сторон уже заблокированы в TransferBalance
+func (pgdb *PostgresDB) transferLots(ctx context.Context, tx pgx.Tx, from, to string, sum amount.Amount, createdAt time.Time) error {
+	var moved amount.Amount
+	row := tx.QueryRow(ctx,
+		`WITH ordered AS (
+			SELECT id, remaining, SUM(remaining) OVER (ORDER BY createdat, id) AS cum
+			FROM public.accruallots WHERE userlogin=$1 AND remaining > 0
+		), taken AS (
+			UPDATE public.accruallots l
+			SET remaining = l.remaining - LEAST(o.remaining, $2 - (o.cum - o.remaining))
+			FROM ordered o
+			WHERE l.id = o.id AND o.cum - o.remaining < $2
+			RETURNING o.remaining - l.remaining AS amount, l.createdat, l.expiresat
+		), moved AS (
+			INSERT INTO public.accruallots (userlogin,amount,remaining,createdat,expiresat)
+			SELECT $3, amount, amount, createdat, expiresat FROM taken WHERE amount > 0
+			RETURNING amount
+		)
+		SELECT COALESCE(SUM(amount), 0)::bigint FROM moved`,
+		from, sum, to,
+	)
+	err := row.Scan(&moved)
+	if err != nil {
+		return err
+	}
+	// партий меньше баланса быть не должно, но остаток не теряем
+	if moved < sum {
+		return pgdb.addLot(ctx, tx, to, sum-moved, createdAt)
+	}
+	return nil
+}
+
+// сжигаем остаток одной просроченной партии. Зарезервированные баллы не сгорают: иначе
+// доступный баланс ушел бы в минус, а списание резерва - в баллы, которых уже нет.
+// Несгоревшая часть остается в партии и сгорит, когда резерв снимут
+func (pgdb *PostgresDB) expireLot(ctx context.Context, lotID int64) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	var userlogin string
+	row := tx.QueryRow(ctx, `SELECT userlogin FROM public.accruallots WHERE id=$1`, lotID)
+	err = row.Scan(&userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	var free amount.Amount
+	row = tx.QueryRow(ctx, `SELECT sumaccrual - sumheld FROM public.balance WHERE userlogin=$1 FOR UPDATE`, userlogin)
+	err = row.Scan(&free)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	// остаток перечитываем под блокировкой баланса, партию могли частично списать
+	var remaining amount.Amount
+	row = tx.QueryRow(ctx,
+		`SELECT remaining FROM public.accruallots WHERE id=$1 AND remaining > 0 AND expiresat <= $2 FOR UPDATE`,
+		lotID, time.Now(),
+	)
+	err = row.Scan(&remaining)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	expired := remaining
+	if expired > free {
+		expired = free
+	}
+	if expired <= 0 {
+		tx.Rollback(ctx)
+		return nil
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.accruallots SET remaining = remaining - $1 WHERE id=$2`, expired, lotID)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	err = pgdb.postEntry(ctx, tx, models.LedgerEntry{
+		UserLogin: userlogin,
+		EntryType: models.EntryExpiry,
+		Amount:    -expired,
+		Reason:    "points expired",
+	})
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// сжигаем все просроченные партии
+func (pgdb *PostgresDB) ExpireLots(ctx context.Context) (int, error) {
+	rows, err := pgdb.pool.Query(ctx, `SELECT id FROM public.accruallots WHERE remaining > 0 AND expiresat <= $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	lotIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lotIDs = append(lotIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range lotIDs {
+		if err := pgdb.expireLot(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(lotIDs), nil
+}
+
+// периодически сжигаем просроченные баллы
+func (pgdb *PostgresDB) RunExpiry(ctx context.Context, interval time.Duration, log *zap.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			expired, err := pgdb.ExpireLots(ctx)
+			if err != nil {
+				log.Error("error in expire points: ", zap.Error(err))
+			}
+			if expired > 0 {
+				log.Info("points expired", zap.Int("lots", expired))
+			}
+		}
+	}
+}
+
+// сколько баллов сгорит в ближайшие expiryNotice и когда сгорит ближайшая партия
+func (pgdb *PostgresDB) expiringSoon(ctx context.Context, userlogin string) (amount.Amount, *time.Time, error) {
+	var soon amount.Amount
+	var nextExpiry *time.Time
+	if pgdb.expiryNotice <= 0 {
+		return soon, nil, nil
+	}
+	row := pgdb.pool.QueryRow(ctx,
+		`SELECT COALESCE(SUM(remaining), 0)::bigint, MIN(expiresat) FROM public.accruallots
+		WHERE userlogin=$1 AND remaining > 0 AND expiresat IS NOT NULL AND expiresat <= $2`,
+		userlogin, time.Now().Add(pgdb.expiryNotice),
+	)
+	err := row.Scan(&soon, &nextExpiry)
+	if err != nil {
+		return soon, nil, err
+	}
+	if nextExpiry != nil {
+		t := models.ServerTime(*nextExpiry)
+		nextExpiry = &t
+	}
+	return soon, nextExpiry, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+)
+
+// партия сгорает после резервирования: зарезервированная часть остается, доступный
+// баланс не уходит в минус, резерв списывается, а остаток партии сгорает позже
+func TestExpireLotsKeepsHeldPoints(t *testing.T) {
+	pgdb := newTestDB(t)
+	pgdb.SetHoldTimeout(time.Hour)
+	ctx := context.Background()
+	login := testLogin()
+	createTestUser(t, pgdb, login, amount.FromInt(100))
+	hold, err := pgdb.CreateHold(ctx, login, testOrderNumber(), amount.FromInt(60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pgdb.pool.Exec(ctx, `UPDATE public.accruallots SET expiresat = $1 WHERE userlogin=$2`, time.Now().Add(-time.Minute), login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pgdb.ExpireLots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := pgdb.GetBalanceDB(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.AccrualSum != amount.FromInt(60) || balance.Available != 0 {
+		t.Errorf("after expiry: current = %s, available = %s, want 60 and 0", balance.AccrualSum, balance.Available)
+	}
+
+	err = pgdb.CaptureHold(ctx, login, hold.HoldID)
+	if err != nil {
+		t.Fatalf("capture after expiry: %v", err)
+	}
+	balance, err = pgdb.GetBalanceDB(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.AccrualSum != 0 || balance.Available != 0 {
+		t.Errorf("after capture: current = %s, available = %s, want 0 and 0", balance.AccrualSum, balance.Available)
+	}
+	var remaining amount.Amount
+	err = pgdb.pool.QueryRow(ctx, `SELECT COALESCE(SUM(remaining), 0)::bigint FROM public.accruallots WHERE userlogin=$1`, login).Scan(&remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("lots remaining = %s, want 0", remaining)
+	}
+}
+
+// возврат списания восстанавливает партии с прежними датами: иначе возвращенные баллы
+// получили бы новый срок сгорания
+func TestReverseWithdrawalKeepsLotDates(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	login := testLogin()
+	number := testOrderNumber()
+	createTestUser(t, pgdb, login, amount.FromInt(100))
+	_, err := pgdb.pool.Exec(ctx,
+		`UPDATE public.accruallots SET createdat = '2020-01-01 00:00:00', expiresat = '2030-01-01 00:00:00' WHERE userlogin=$1`, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pgdb.WithdrawBalanceDB(ctx, login, number, amount.FromInt(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pgdb.ReverseWithdrawal(ctx, number, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total, kept amount.Amount
+	err = pgdb.pool.QueryRow(ctx,
+		`SELECT COALESCE(SUM(remaining), 0)::bigint,
+			COALESCE(SUM(remaining) FILTER (WHERE createdat = '2020-01-01 00:00:00' AND expiresat = '2030-01-01 00:00:00'), 0)::bigint
+		FROM public.accruallots WHERE userlogin=$1`, login).Scan(&total, &kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != amount.FromInt(100) || kept != total {
+		t.Errorf("lots remaining = %s, with original dates = %s, want 100 and 100", total, kept)
+	}
+}
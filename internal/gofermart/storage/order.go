@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/MlDenis/internal/amount"
 	"github.com/MlDenis/internal/gofermart/models"
 	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5"
 )
 
 // записываем заказы пользователя
@@ -30,37 +34,99 @@ func (pgdb *PostgresDB) LoadOrderInDB(ctx context.Context, orders *models.Orders
 		return tx.Commit(ctx)
 	}
 	orders.StatusOrder = models.NewOrder
-	_, err = tx.Exec(ctx,
-		`INSERT INTO public.orders (ordernumber,userlogin,orderdate,statusorder) VALUES ($1, $2,$3, $4)`,
+	//при конфликте номера смотрим, кому принадлежит заказ:
+	//pkg.OrderAlreadyUploaded - этому же пользователю, pkg.OrderUploadedByAnotherUser - другому
+	var owner string
+	var inserted bool
+	row := tx.QueryRow(ctx,
+		`WITH inserted AS (
+			INSERT INTO public.orders (ordernumber,userlogin,orderdate,statusorder) VALUES ($1, $2,$3, $4)
+			ON CONFLICT (ordernumber) DO NOTHING
+			RETURNING userlogin
+		)
+		SELECT userlogin, true FROM inserted
+		UNION ALL
+		SELECT userlogin, false FROM public.orders WHERE ordernumber=$1 AND NOT EXISTS (SELECT 1 FROM inserted)`,
 		orders.OrderNumber, orders.UserLogin, orders.OrderDate, orders.StatusOrder,
 	)
-	if err != nil {
+	err = row.Scan(&owner, &inserted)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 
 		tx.Rollback(ctx)
 		return err
 	}
+	if err == nil && inserted {
+		err = pgdb.recordOrderStatus(ctx, tx, orders.OrderNumber, "", models.NewOrder, "", orders.OrderDate)
+		if err != nil {
 
-	return tx.Commit(ctx)
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		//конфликтующий заказ зафиксирован параллельно и не виден в снимке запроса, перечитываем
+		tx.Rollback(ctx)
+		err = pgdb.pool.QueryRow(ctx, `SELECT userlogin FROM public.orders WHERE ordernumber=$1`, orders.OrderNumber).Scan(&owner)
+		if err != nil {
+			return err
+		}
+	} else if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	switch {
+	case inserted:
+		return nil
+	case owner == orders.UserLogin:
+		return pkg.OrderAlreadyUploaded
+	default:
+		return pkg.OrderUploadedByAnotherUser
+	}
 
 }
 
-func (pgdb *PostgresDB) GetUserOrders(ctx context.Context, userlogin string) ([]models.OrdersOnly, error) {
-	orders := []models.OrdersOnly{}
-	rows, err := pgdb.pool.Query(ctx, `SELECT ordernumber, orderdate, statusorder FROM public.orders WHERE userlogin = $1`, userlogin) // дописать accrual, withdraw когда сделаем систему
+// заказы пользователя по фильтру. Сортировка по времени загрузки и id,
+// запрос идет по индексу orders_userlogin_orderdate_idx в обе стороны
+func (pgdb *PostgresDB) GetUserOrders(ctx context.Context, userlogin string, filter models.OrdersFilter) ([]models.Orders, error) {
+	orders := []models.Orders{}
+	direction, after := "ASC", ">"
+	if filter.Desc {
+		direction, after = "DESC", "<"
+	}
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	var statuses []string
+	if len(filter.Statuses) > 0 {
+		statuses = filter.Statuses
+	}
+	rows, err := pgdb.pool.Query(ctx,
+		`SELECT id, ordernumber, orderdate, statusorder, accrual FROM public.orders
+		WHERE userlogin = $1
+			AND ($2::text[] IS NULL OR statusorder = ANY($2::text[]))
+			AND ($3::timestamp IS NULL OR orderdate >= $3::timestamp)
+			AND ($4::timestamp IS NULL OR orderdate < $4::timestamp)
+			AND ($5::timestamp IS NULL OR (orderdate, id) `+after+` ($5::timestamp, $6::bigint))
+		ORDER BY orderdate `+direction+`, id `+direction+`
+		LIMIT $7`,
+		userlogin, statuses, filter.From, filter.To, filter.AfterTime, filter.AfterID, limit,
+	)
 	if err != nil {
 		return orders, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		order := models.OrdersOnly{}
-		err := rows.Scan(&order.OrderNumber, &order.OrderDate, &order.StatusOrder)
+		order := models.Orders{UserLogin: userlogin}
+		err := rows.Scan(&order.ID, &order.OrderNumber, &order.OrderDate, &order.StatusOrder, &order.Accrual)
 		if err != nil {
 			return orders, err
 		}
 		orders = append(orders, order)
 	}
-
-	defer rows.Close()
+	if err := rows.Err(); err != nil {
+		return orders, err
+	}
 
 	if len(orders) == 0 {
 		return orders, pkg.NoOrders
@@ -72,17 +138,30 @@ func (pgdb *PostgresDB) GetUserOrders(ctx context.Context, userlogin string) ([]
 // Получение информации о выводе средств из бд
 func (pgdb *PostgresDB) GetWithdrawalsDB(ctx context.Context, userlogin string) ([]models.WithdrawOrder, error) {
 	withdrawals := []models.WithdrawOrder{}
-	rows, err := pgdb.pool.Query(ctx, `SELECT ordernumber, withdraw, orderdate FROM public.orders WHERE userlogin = $1 and statusorder=$2`, userlogin, models.WithdrawEnd) // дописать accrual, withdraw когда сделаем систему
+	//кроме списаний по заказам показываем переводы другим пользователям, от старых к новым
+	rows, err := pgdb.pool.Query(ctx,
+		`SELECT ordernumber, withdraw, orderdate, '', '', reversedat FROM public.orders WHERE userlogin = $1 and statusorder=$2
+		UNION ALL
+		SELECT NULL, -l.amount, l.createdat, $4::text, COALESCE(c.userlogin, ''), NULL FROM public.ledger l
+			LEFT JOIN public.ledgeraccounts c ON c.id = l.counterpartyid
+			WHERE l.accountid = (SELECT id FROM public.ledgeraccounts WHERE userlogin = $1) AND l.account = $3 AND l.entrytype = $5
+		ORDER BY 3`,
+		userlogin, models.WithdrawEnd, models.LedgerUserAccount, models.WithdrawTypeTransfer, models.EntryTransferOut,
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		withdraw := models.WithdrawOrder{}
-		err := rows.Scan(&withdraw.Order, &withdraw.Sum, &withdraw.ProcessedAt)
+		var orderNumber *string
+		err := rows.Scan(&orderNumber, &withdraw.Sum, &withdraw.ProcessedAt, &withdraw.Type, &withdraw.Counterparty, &withdraw.ReversedAt)
 		if err != nil {
 			return nil, err
 		}
+		if orderNumber != nil {
+			withdraw.Order = models.OrderNumber(*orderNumber)
+		}
 		withdrawals = append(withdrawals, withdraw)
 	}
 
@@ -129,13 +208,36 @@ func (pgdb *PostgresDB) GetAllOrders(ctx context.Context) ([]models.OrdersOnly,
 
 }
 
-func (pgdb *PostgresDB) EditStatusAndAccrualOrder(ctx context.Context, status string, accrual, ordernumber int64) error {
+// меняем статус заказа на начисление и пишем переход в журнал статусов.
+// Повторная установка PROCESSING ничего не меняет, остальные недопустимые
+// переходы, в том числе из конечных статусов, возвращают pkg.IllegalStatusTransition.
+// При переходе в PROCESSED баллы начисляются владельцу заказа в той же транзакции
+func (pgdb *PostgresDB) EditStatusAndAccrualOrder(ctx context.Context, status string, accrual amount.Amount, ordernumber string, reason string) error {
 	tx, err := pgdb.pool.Begin(ctx)
 	if err != nil {
 
 		return err
 	}
 
+	var current, owner string
+	err = tx.QueryRow(ctx, `SELECT statusorder, userlogin FROM public.orders WHERE ordernumber=$1 FOR UPDATE`, ordernumber).Scan(&current, &owner)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pkg.OrderNotFound
+		}
+		return err
+	}
+	if current == status && status == models.ProcessingOrder {
+		return tx.Commit(ctx)
+	}
+	if !transitionAllowed(current, status) {
+
+		tx.Rollback(ctx)
+		return fmt.Errorf("%w: %s -> %s", pkg.IllegalStatusTransition, current, status)
+	}
+
 	_, err = tx.Exec(ctx,
 		`UPDATE public.orders set accrual = $1, statusorder = $2 WHERE ordernumber=$3`,
 		accrual, status, ordernumber,
@@ -145,5 +247,43 @@ func (pgdb *PostgresDB) EditStatusAndAccrualOrder(ctx context.Context, status st
 		tx.Rollback(ctx)
 		return err
 	}
+	err = pgdb.recordOrderStatus(ctx, tx, ordernumber, current, status, reason, time.Now())
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	//PROCESSED конечный статус: если бы начисление шло отдельной транзакцией и не прошло,
+	//повторить его было бы уже нельзя
+	if status == models.ProcessedOrder && accrual > 0 {
+		err = pgdb.postEntry(ctx, tx, models.LedgerEntry{
+			UserLogin:   owner,
+			EntryType:   models.EntryAccrual,
+			Amount:      accrual,
+			OrderNumber: ordernumber,
+		})
+		if err != nil {
+
+			tx.Rollback(ctx)
+			return err
+		}
+	}
 	return tx.Commit(ctx)
 }
+
+// заказ пользователя на начисление по номеру, чужой заказ и списания не отдаем
+func (pgdb *PostgresDB) GetUserOrder(ctx context.Context, userlogin string, ordernumber string) (*models.Orders, error) {
+	order := &models.Orders{UserLogin: userlogin}
+	err := pgdb.pool.QueryRow(ctx,
+		`SELECT id, ordernumber, orderdate, statusorder, accrual FROM public.orders WHERE ordernumber=$1 AND userlogin=$2 AND statusorder<>$3`,
+		ordernumber, userlogin, models.WithdrawEnd,
+	).Scan(&order.ID, &order.OrderNumber, &order.OrderDate, &order.StatusOrder, &order.Accrual)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, pkg.OrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
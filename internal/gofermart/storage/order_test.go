@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+)
+
+// загрузка заказа: новый заказ, повтор тем же пользователем и заказ другого пользователя
+func TestLoadOrderInDB(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	owner, other := testLogin(), testLogin()
+	number := testOrderNumber()
+
+	err := pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: owner, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	if err != nil {
+		t.Fatalf("new order: %v", err)
+	}
+	var history int
+	err = pgdb.pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM public.order_status_history WHERE ordernumber=$1 AND fromstatus IS NULL AND tostatus=$2`,
+		number, models.NewOrder,
+	).Scan(&history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history != 1 {
+		t.Errorf("history rows for new order = %d, want 1", history)
+	}
+
+	err = pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: owner, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	if !errors.Is(err, pkg.OrderAlreadyUploaded) {
+		t.Errorf("same owner: err = %v, want %v", err, pkg.OrderAlreadyUploaded)
+	}
+
+	err = pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: other, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	if !errors.Is(err, pkg.OrderUploadedByAnotherUser) {
+		t.Errorf("other owner: err = %v, want %v", err, pkg.OrderUploadedByAnotherUser)
+	}
+
+	var storedOwner string
+	err = pgdb.pool.QueryRow(ctx, `SELECT userlogin FROM public.orders WHERE ordernumber=$1`, number).Scan(&storedOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedOwner != owner {
+		t.Errorf("order owner = %q, want %q", storedOwner, owner)
+	}
+}
+
+// конфликтующий заказ фиксируется, пока вставка ждет блокировку: в снимке запроса его нет,
+// владелец перечитывается после отката
+func TestLoadOrderInDBConcurrentInsert(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	owner, other := testLogin(), testLogin()
+	number := testOrderNumber()
+
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	var pid int32
+	err = tx.QueryRow(ctx, `SELECT pg_backend_pid()`).Scan(&pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO public.orders (ordernumber,userlogin,orderdate,statusorder) VALUES ($1, $2, $3, $4)`,
+		number, owner, time.Now(), models.NewOrder,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: other, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	}()
+
+	// ждем, пока вставка встанет на блокировку незафиксированной строки
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var blocked bool
+		err = pgdb.pool.QueryRow(ctx,
+			`SELECT EXISTS(SELECT 1 FROM pg_stat_activity WHERE $1::int = ANY(pg_blocking_pids(pid)))`, pid,
+		).Scan(&blocked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("insert is not blocked by the uncommitted order")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("LoadOrderInDB did not return")
+	}
+	if !errors.Is(err, pkg.OrderUploadedByAnotherUser) {
+		t.Errorf("err = %v, want %v", err, pkg.OrderUploadedByAnotherUser)
+	}
+}
+
+// переход в PROCESSED начисляет баллы владельцу той же транзакцией, повторный переход не начисляет
+func TestEditStatusAndAccrualOrderProcessed(t *testing.T) {
+	pgdb := newTestDB(t)
+	ctx := context.Background()
+	owner := testLogin()
+	number := testOrderNumber()
+	createTestUser(t, pgdb, owner, 0)
+	err := pgdb.LoadOrderInDB(ctx, &models.Orders{UserLogin: owner, OrdersOnly: models.OrdersOnly{OrderNumber: number}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accrual := amount.FromInt(42)
+	err = pgdb.EditStatusAndAccrualOrder(ctx, models.ProcessedOrder, accrual, number, "")
+	if err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	err = pgdb.EditStatusAndAccrualOrder(ctx, models.ProcessedOrder, accrual, number, "")
+	if !errors.Is(err, pkg.IllegalStatusTransition) {
+		t.Errorf("repeated processed: err = %v, want %v", err, pkg.IllegalStatusTransition)
+	}
+
+	var sumAccrual amount.Amount
+	err = pgdb.pool.QueryRow(ctx, `SELECT sumaccrual FROM public.balance WHERE userlogin=$1`, owner).Scan(&sumAccrual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sumAccrual != accrual {
+		t.Errorf("sumaccrual = %s, want %s", sumAccrual, accrual)
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// допустимые переходы статусов заказа на начисление. INVALID и PROCESSED конечные
+var orderTransitions = map[string][]string{
+	models.NewOrder:        {models.ProcessingOrder, models.InvalidOrder, models.ProcessedOrder},
+	models.ProcessingOrder: {models.InvalidOrder, models.ProcessedOrder},
+}
+
+func transitionAllowed(from, to string) bool {
+	for _, status := range orderTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// записываем смену статуса заказа в журнал, from пустой - заказ только что загружен
+func (pgdb *PostgresDB) recordOrderStatus(ctx context.Context, tx pgx.Tx, ordernumber string, from string, to string, reason string, changedAt time.Time) error {
+	var fromStatus *string
+	if from != "" {
+		fromStatus = &from
+	}
+	var reasonText *string
+	if reason != "" {
+		reasonText = &reason
+	}
+	_, err := tx.Exec(ctx,
+		`INSERT INTO public.order_status_history (ordernumber,fromstatus,tostatus,reason,changedat) VALUES ($1, $2, $3, $4, $5)`,
+		ordernumber, fromStatus, to, reasonText, changedAt,
+	)
+	return err
+}
+
+// история статусов заказа от старых к новым
+func (pgdb *PostgresDB) GetOrderStatusHistory(ctx context.Context, ordernumber string) ([]models.OrderStatusChange, error) {
+	history := []models.OrderStatusChange{}
+	rows, err := pgdb.pool.Query(ctx,
+		`SELECT fromstatus, tostatus, reason, changedat FROM public.order_status_history WHERE ordernumber=$1 ORDER BY changedat, id`,
+		ordernumber,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		change := models.OrderStatusChange{}
+		var from, reason *string
+		err := rows.Scan(&from, &change.To, &reason, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		if from != nil {
+			change.From = *from
+		}
+		if reason != nil {
+			change.Reason = *reason
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+	"github.com/jackc/pgx/v5"
+)
+
+// записываем сессию пользователя в бд
+func (pgdb *PostgresDB) AddSession(ctx context.Context, session models.Session) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO public.sessions (token,tokenid,sessionid,userlogin,expiresat) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (token) DO UPDATE SET tokenid = EXCLUDED.tokenid, sessionid = EXCLUDED.sessionid, userlogin = EXCLUDED.userlogin, expiresat = EXCLUDED.expiresat`,
+		session.Token, session.TokenID, session.SessionID, session.Login, session.ExpiresAt,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// получаем действующую сессию по токену
+func (pgdb *PostgresDB) GetSession(ctx context.Context, token string) (*models.Session, error) {
+	session := &models.Session{}
+	row := pgdb.pool.QueryRow(ctx, `SELECT token, tokenid, sessionid, userlogin, expiresat FROM public.sessions WHERE token=$1 AND expiresat > now()`, token)
+	err := row.Scan(&session.Token, &session.TokenID, &session.SessionID, &session.Login, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pkg.TokenNotExist
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// удаляем просроченные сессии и отозванные токены, срок которых уже истек
+func (pgdb *PostgresDB) DeleteExpiredSessions(ctx context.Context) error {
+	_, err := pgdb.pool.Exec(ctx, `DELETE FROM public.sessions WHERE expiresat <= now()`)
+	if err != nil {
+		return err
+	}
+	_, err = pgdb.pool.Exec(ctx, `DELETE FROM public.revokedtokens WHERE expiresat <= now()`)
+	if err != nil {
+		return err
+	}
+	_, err = pgdb.pool.Exec(ctx, `DELETE FROM public.refreshtokens WHERE expiresat <= now()`)
+	return err
+}
+
+// отзываем токен и удаляем его сессию
+func (pgdb *PostgresDB) RevokeToken(ctx context.Context, revoked models.RevokedToken) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO public.revokedtokens (tokenid,userlogin,expiresat) VALUES ($1, $2, $3) ON CONFLICT (tokenid) DO NOTHING`,
+		revoked.TokenID, revoked.Login, revoked.ExpiresAt,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM public.sessions WHERE tokenid=$1`, revoked.TokenID)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// отзываем все токены пользователя, включая refresh-токены, кроме сессии exceptSessionID
+func (pgdb *PostgresDB) RevokeAllTokens(ctx context.Context, login, exceptSessionID string) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	err = revokeAllTokens(ctx, tx, login, exceptSessionID)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// отзыв токенов в переданной транзакции, используется и при удалении аккаунта
+func revokeAllTokens(ctx context.Context, tx pgx.Tx, login, exceptSessionID string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO public.revokedtokens (tokenid,userlogin,expiresat)
+		SELECT tokenid, userlogin, expiresat FROM public.sessions
+		WHERE userlogin=$1 AND tokenid <> '' AND ($2 = '' OR sessionid <> $2)
+		ON CONFLICT (tokenid) DO NOTHING`,
+		login, exceptSessionID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM public.sessions WHERE userlogin=$1 AND ($2 = '' OR sessionid <> $2)`, login, exceptSessionID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE public.refreshtokens SET revoked = true WHERE userlogin=$1 AND ($2 = '' OR familyid <> $2)`, login, exceptSessionID)
+	return err
+}
+
+// проверяем, есть ли токен в списке отозванных
+func (pgdb *PostgresDB) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	row := pgdb.pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM public.revokedtokens WHERE tokenid=$1)`, tokenID)
+	err := row.Scan(&revoked)
+	return revoked, err
+}
+
+// сохраняем refresh-токен
+func (pgdb *PostgresDB) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	_, err := pgdb.pool.Exec(ctx,
+		`INSERT INTO public.refreshtokens (tokenhash,familyid,userlogin,expiresat) VALUES ($1, $2, $3, $4)`,
+		token.TokenHash, token.FamilyID, token.Login, token.ExpiresAt,
+	)
+	return err
+}
+
+// получаем refresh-токен по хэшу
+func (pgdb *PostgresDB) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	refresh := &models.RefreshToken{}
+	row := pgdb.pool.QueryRow(ctx,
+		`SELECT tokenhash, familyid, userlogin, expiresat, used, revoked FROM public.refreshtokens WHERE tokenhash=$1`,
+		tokenHash,
+	)
+	err := row.Scan(&refresh.TokenHash, &refresh.FamilyID, &refresh.Login, &refresh.ExpiresAt, &refresh.Used, &refresh.Revoked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pkg.RefreshTokenInvalid
+		}
+		return nil, err
+	}
+	return refresh, nil
+}
+
+// помечаем refresh-токен использованным, false если его уже использовали
+func (pgdb *PostgresDB) MarkRefreshTokenUsed(ctx context.Context, tokenHash string) (bool, error) {
+	tag, err := pgdb.pool.Exec(ctx,
+		`UPDATE public.refreshtokens SET used = true WHERE tokenhash=$1 AND NOT used AND NOT revoked`,
+		tokenHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// отзываем все refresh-токены семейства
+func (pgdb *PostgresDB) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := pgdb.pool.Exec(ctx, `UPDATE public.refreshtokens SET revoked = true WHERE familyid=$1`, familyID)
+	return err
+}
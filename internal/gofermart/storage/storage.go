@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"time"
 
+	"github.com/MlDenis/internal/amount"
 	"github.com/MlDenis/internal/gofermart/models"
 	"go.uber.org/zap"
 )
@@ -11,25 +13,48 @@ type Interface interface {
 	InterfaceUser
 	InterfaceOrders
 	InterfaceBalance
+	InterfaceIdempotency
 }
 type InterfaceUser interface {
 	RegisterUser(ctx context.Context, userData models.UserData) error
 	GetUser(ctx context.Context, userData *models.UserData) error
+	UpdatePasswordHash(ctx context.Context, userlogin string, hash string) error
+	DeleteUser(ctx context.Context, userlogin string) error
+	SetTOTPSecret(ctx context.Context, userlogin string, secret string) error
+	GetTOTP(ctx context.Context, userlogin string) (*models.TOTP, error)
+	EnableTOTP(ctx context.Context, userlogin string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userlogin string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userlogin string, codeHash string) (bool, error)
+	SetUserRole(ctx context.Context, userlogin string, role string) error
 	AuthorizationBalance(ctx context.Context, userlogin string) error
 }
 
 type InterfaceOrders interface {
 	LoadOrderInDB(ctx context.Context, orderrData *models.Orders) error
-	GetUserOrders(ctx context.Context, userlogin string) ([]models.OrdersOnly, error)
+	GetUserOrders(ctx context.Context, userlogin string, filter models.OrdersFilter) ([]models.Orders, error)
 	GetAllOrders(ctx context.Context) ([]models.OrdersOnly, error)
-	EditStatusAndAccrualOrder(ctx context.Context, status string, accrual, ordernumber int64) error
-	EditBalanceAccrual(ctx context.Context, userlogin string, accrual int64) error
+	GetUserOrder(ctx context.Context, userlogin string, ordernumber string) (*models.Orders, error)
+	GetOrderStatusHistory(ctx context.Context, ordernumber string) ([]models.OrderStatusChange, error)
+	EditStatusAndAccrualOrder(ctx context.Context, status string, accrual amount.Amount, ordernumber string, reason string) error
 }
 type InterfaceBalance interface {
 	GetBalanceDB(ctx context.Context, userlogin string) (*models.ResponseBalance, error)
-	LoadOrderInDB(ctx context.Context, orderrData *models.Orders) error
-	EditBalanceWithdraw(ctx context.Context, userlogin string, sumwithdraw int64) error
+	TransferBalance(ctx context.Context, from, to string, sum amount.Amount, comment string) error
+	ReverseWithdrawal(ctx context.Context, ordernumber string, reason string) error
+	CreateHold(ctx context.Context, userlogin string, ordernumber string, sum amount.Amount) (*models.Hold, error)
+	CaptureHold(ctx context.Context, userlogin, holdID string) error
+	ReleaseHold(ctx context.Context, userlogin, holdID string) error
+	WithdrawBalanceDB(ctx context.Context, userlogin string, ordernumber string, sumwithdraw amount.Amount) error
 	GetWithdrawalsDB(ctx context.Context, userlogin string) ([]models.WithdrawOrder, error)
+	AdjustBalance(ctx context.Context, userlogin string, sum amount.Amount, reason string) error
+	ReconcileLedger(ctx context.Context) ([]models.LedgerMismatch, error)
+	GetBalanceHistory(ctx context.Context, userlogin string, filter models.BalanceHistoryFilter) ([]models.BalanceHistoryEntry, error)
+}
+
+type InterfaceIdempotency interface {
+	BeginIdempotentRequest(ctx context.Context, userlogin, key, fingerprint string, expiredBefore, abandonedBefore time.Time) (*models.IdempotencyRecord, bool, error)
+	CompleteIdempotentRequest(ctx context.Context, userlogin, key string, statusCode int, contentType string, body []byte) error
+	DeleteIdempotencyKey(ctx context.Context, userlogin, key string) error
 }
 
 func NewStorage(ctx context.Context, migratePath string, postgresDSN string, log *zap.Logger) (Interface, *PostgresDB, error) {
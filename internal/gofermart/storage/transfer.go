@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/MlDenis/internal/amount"
+	"github.com/MlDenis/internal/gofermart/models"
+	"github.com/MlDenis/pkg"
+)
+
+// перевод баллов другому пользователю одной транзакцией.
+// строки баланса обеих сторон блокируем в порядке логинов, чтобы встречные переводы не зависали
+func (pgdb *PostgresDB) TransferBalance(ctx context.Context, from, to string, sum amount.Amount, comment string) error {
+	if from == to {
+		return pkg.TransferToSelf
+	}
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	var locked int
+	row := tx.QueryRow(ctx,
+		`SELECT COUNT(*) FROM (SELECT userlogin FROM public.balance WHERE userlogin IN ($1, $2) ORDER BY userlogin FOR UPDATE) b`,
+		from, to,
+	)
+	err = row.Scan(&locked)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	if locked != 2 {
+		tx.Rollback(ctx)
+		return pkg.UserNotExist
+	}
+
+	now := time.Now()
+	if pgdb.transferDailyLimit > 0 {
+		var sentToday amount.Amount
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		row = tx.QueryRow(ctx,
+			`SELECT COALESCE(-SUM(amount), 0)::bigint FROM public.ledger
+			WHERE accountid=(SELECT id FROM public.ledgeraccounts WHERE userlogin=$1) AND account=$2 AND entrytype=$3 AND createdat >= $4`,
+			from, models.LedgerUserAccount, models.EntryTransferOut, dayStart,
+		)
+		err = row.Scan(&sentToday)
+		if err != nil {
+
+			tx.Rollback(ctx)
+			return err
+		}
+		if sentToday+sum > pgdb.transferDailyLimit {
+			tx.Rollback(ctx)
+			return pkg.TransferLimitExceeded
+		}
+	}
+
+	err = pgdb.postEntry(ctx, tx, models.LedgerEntry{
+		UserLogin:    from,
+		EntryType:    models.EntryTransferOut,
+		Amount:       -sum,
+		Reason:       comment,
+		Counterparty: to,
+		CreatedAt:    now,
+	})
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	err = pgdb.postEntry(ctx, tx, models.LedgerEntry{
+		UserLogin:    to,
+		EntryType:    models.EntryTransferIn,
+		Amount:       sum,
+		Reason:       comment,
+		Counterparty: from,
+		CreatedAt:    now,
+	})
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	err = pgdb.transferLots(ctx, tx, from, to, sum, now)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/MlDenis/internal/gofermart/models"
+)
+
+// сохраняем секрет TOTP, до подтверждения 2FA остается выключенной
+func (pgdb *PostgresDB) SetTOTPSecret(ctx context.Context, userlogin string, secret string) error {
+	_, err := pgdb.pool.Exec(ctx,
+		`UPDATE public.users SET totpsecret = $1, totpenabled = false, totplaststep = 0 WHERE userlogin=$2`,
+		secret, userlogin,
+	)
+	return err
+}
+
+// получаем настройки 2FA пользователя
+func (pgdb *PostgresDB) GetTOTP(ctx context.Context, userlogin string) (*models.TOTP, error) {
+	totp := &models.TOTP{}
+	row := pgdb.pool.QueryRow(ctx,
+		`SELECT COALESCE(totpsecret, ''), totpenabled, totplaststep FROM public.users WHERE userlogin=$1`,
+		userlogin,
+	)
+	err := row.Scan(&totp.Secret, &totp.Enabled, &totp.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+// включаем 2FA и заменяем коды восстановления на новые
+func (pgdb *PostgresDB) EnableTOTP(ctx context.Context, userlogin string, step int64, recoveryCodeHashes []string) error {
+	tx, err := pgdb.pool.Begin(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE public.users SET totpenabled = true, totplaststep = $1 WHERE userlogin=$2`,
+		step, userlogin,
+	)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM public.recoverycodes WHERE userlogin=$1`, userlogin)
+	if err != nil {
+
+		tx.Rollback(ctx)
+		return err
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(ctx, `INSERT INTO public.recoverycodes (userlogin,codehash) VALUES ($1, $2)`, userlogin, codeHash)
+		if err != nil {
+
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// запоминаем использованный шаг TOTP, false если этот или более поздний код уже использовали
+func (pgdb *PostgresDB) UseTOTPStep(ctx context.Context, userlogin string, step int64) (bool, error) {
+	tag, err := pgdb.pool.Exec(ctx,
+		`UPDATE public.users SET totplaststep = $1 WHERE userlogin=$2 AND totplaststep < $1`,
+		step, userlogin,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// используем код восстановления, false если такого неиспользованного кода нет
+func (pgdb *PostgresDB) UseRecoveryCode(ctx context.Context, userlogin string, codeHash string) (bool, error) {
+	tag, err := pgdb.pool.Exec(ctx,
+		`UPDATE public.recoverycodes SET usedat = now() WHERE userlogin=$1 AND codehash=$2 AND usedat IS NULL`,
+		userlogin, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -1,23 +1,47 @@
 package luna
 
-func Valid(number int64) bool {
-	return (number%10+checksum(number/10))%10 == 0
-}
-
-func checksum(number int64) int64 {
-	var luhn int64
+// номер заказа - непустая строка из цифр любой длины с верной контрольной суммой Луна
+func Valid(number string) bool {
+	if number == "" {
+		return false
+	}
+	var luhn int
 
-	for i := 0; number > 0; i++ {
-		cur := number % 10
+	for i := 0; i < len(number); i++ {
+		cur := int(number[len(number)-1-i] - '0')
+		if cur < 0 || cur > 9 {
+			return false
+		}
 
-		if i%2 == 0 { // even
+		if i%2 == 1 { // каждая вторая цифра справа
 			cur = cur * 2
 			if cur > 9 {
 				cur = cur%10 + cur/10
 			}
 		}
 		luhn += cur
-		number = number / 10
 	}
-	return luhn % 10
+	return luhn%10 == 0
+}
+
+// строка состоит только из цифр: проверка формата номера до проверки контрольной суммы
+func Numeric(number string) bool {
+	if number == "" {
+		return false
+	}
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// убираем ведущие нули, оставляя хотя бы одну цифру. Ведущий ноль не меняет сумму Луна,
+// и номера всегда принимались как число, поэтому 0123 и 123 - один и тот же заказ
+func Normalize(number string) string {
+	for len(number) > 1 && number[0] == '0' {
+		number = number[1:]
+	}
+	return number
 }
@@ -0,0 +1,41 @@
+package luna
+
+import "testing"
+
+func TestNumeric(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"12345678903", true},
+		{"0", true},
+		{"012345678903", true},
+		{"00", true},
+		{"", false},
+		{"123a", false},
+		{"-123", false},
+	}
+	for _, tt := range tests {
+		if got := Numeric(tt.number); got != tt.want {
+			t.Errorf("Numeric(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{"12345678903", "12345678903"},
+		{"012345678903", "12345678903"},
+		{"000123", "123"},
+		{"0", "0"},
+		{"00", "0"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.number); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
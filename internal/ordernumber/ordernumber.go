@@ -0,0 +1,59 @@
+// Package ordernumber - номер заказа, общий для gophermart и системы расчета начислений.
+//
+// Номер - строка цифр произвольной длины. В JSON его присылают и строкой, и числом
+// (так было до перехода на строки), поэтому принимаются оба варианта, а отдается строка.
+package ordernumber
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Number - номер заказа строкой цифр
+type Number string
+
+var ErrSyntax = errors.New("order number must be a string or an integer")
+
+// принимаем строку и целое число, число берем как записано, без перевода через float64
+func (n *Number) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*n = Number(str)
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return ErrSyntax
+		}
+	}
+	*n = Number(s)
+	return nil
+}
+
+// в бд номер хранится строкой (TEXT)
+func (n Number) Value() (driver.Value, error) {
+	return string(n), nil
+}
+
+func (n *Number) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*n = ""
+	case string:
+		*n = Number(v)
+	case []byte:
+		*n = Number(v)
+	default:
+		return fmt.Errorf("ordernumber: cannot scan %T", src)
+	}
+	return nil
+}
@@ -9,3 +9,17 @@ const TokenNotExist = Error("Token does not exist")
 const UniqueViolationCode = "23505"
 const uniqueViolationOrders = Error(`ERROR: duplicate key value violates unique constraint "orders_ordernumber_userlogin_key (SQLSTATE 23505)`) 
 const NoOrders = Error("User doesn't have any orders")
+const RefreshTokenInvalid = Error("Refresh token is invalid or expired")
+const RefreshTokenReused = Error("Refresh token has already been used")
+const InsufficientFunds = Error("Insufficient funds")
+const UserNotExist = Error("User does not exist")
+const TransferLimitExceeded = Error("Daily transfer limit exceeded")
+const TransferToSelf = Error("Cannot transfer points to yourself")
+const WithdrawalNotFound = Error("Withdrawal does not exist")
+const WithdrawalAlreadyReversed = Error("Withdrawal has already been reversed")
+const HoldNotFound = Error("Hold does not exist")
+const HoldNotActive = Error("Hold has already been captured, released or expired")
+const OrderAlreadyUploaded = Error("Order has already been uploaded by this user")
+const OrderUploadedByAnotherUser = Error("Order has already been uploaded by another user")
+const OrderNotFound = Error("Order does not exist")
+const IllegalStatusTransition = Error("Illegal order status transition")